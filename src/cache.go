@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"psort/src/sorter"
+)
+
+// cacheFileName is the file --cache keeps its entries in, next to psort.json.
+const cacheFileName = ".psortcache"
+
+// cache remembers the content hash of files known to be sorted, so that a
+// later run can skip them after hashing. Entries are only valid for the
+// config and options they were recorded with.
+type cache struct {
+	path string
+
+	mu    sync.Mutex
+	entry cacheFile
+	dirty bool
+}
+
+// cacheFile is the content of .psortcache.
+type cacheFile struct {
+	// Config is the fingerprint of the config the files were sorted with
+	Config string `json:"config"`
+	// Files maps paths to the SHA-256 of their sorted content
+	Files map[string]string `json:"files"`
+}
+
+// cacheFingerprint identifies what files are sorted with: the config and
+// the options that change the sorted output, which is only --converge.
+func cacheFingerprint(config *sorter.Config, opts *Options) string {
+	if opts.Converge {
+		return config.Fingerprint() + "+converge"
+	}
+	return config.Fingerprint()
+}
+
+// loadCache reads the cache next to the config. A missing or unreadable
+// cache, or one recorded with another config, starts empty.
+func loadCache(root, fingerprint string) *cache {
+	c := &cache{
+		path:  filepath.Join(root, cacheFileName),
+		entry: cacheFile{Config: fingerprint, Files: make(map[string]string)},
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return c
+	}
+	var entry cacheFile
+	if json.Unmarshal(data, &entry) == nil && entry.Config == fingerprint && entry.Files != nil {
+		c.entry = entry
+	}
+	return c
+}
+
+// sorted reports whether a file is unchanged since it was last recorded as
+// sorted.
+func (c *cache) sorted(path string) bool {
+	sum, err := hashFile(path)
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entry.Files[filepath.Clean(path)] == sum
+}
+
+// record notes that a file is sorted as it is now on disk.
+func (c *cache) record(path string) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry.Files[filepath.Clean(path)] = sum
+	c.dirty = true
+}
+
+// save writes the cache back if anything was recorded.
+func (c *cache) save() error {
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.entry)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(c.path, data, 0o644)
+	if errors.Is(err, os.ErrPermission) {
+		// A read-only checkout still works, just without the speedup
+		return nil
+	}
+	return err
+}
+
+// hashFile returns the SHA-256 of a file's content, streaming it rather than
+// reading it whole.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns n lines "line 1\n" to "line n\n", with the lines
+// at the given positions replaced by "changed k\n".
+func numberedLines(n int, changed ...int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if slices.Contains(changed, i) {
+			fmt.Fprintf(&b, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+	}
+	return b.String()
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed []int
+		headers []string
+	}{
+		{"one change", []int{10}, []string{"@@ -7,7 +7,7 @@"}},
+		// 2*diffContext unchanged lines between the changes: the contexts touch
+		{"gap of six", []int{5, 12}, []string{"@@ -2,14 +2,14 @@"}},
+		{"gap of seven", []int{5, 13}, []string{"@@ -2,7 +2,7 @@", "@@ -10,7 +10,7 @@"}},
+		{"at the edges", []int{1, 20}, []string{"@@ -1,4 +1,4 @@", "@@ -17,4 +17,4 @@"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := unifiedDiff("a.php", []byte(numberedLines(20)), []byte(numberedLines(20, tt.changed...)))
+			var headers []string
+			for _, line := range strings.Split(diff, "\n") {
+				if strings.HasPrefix(line, "@@") {
+					headers = append(headers, line)
+				}
+			}
+			if strings.Join(headers, "\n") != strings.Join(tt.headers, "\n") {
+				t.Errorf("hunks = %q, want %q\n%s", headers, tt.headers, diff)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "<?php\nuse B;\nuse A;\n"
+	want := "--- a/a.php\n+++ b/a.php\n@@ -1,3 +1,3 @@\n <?php\n-use B;\n use A;\n+use B;\n"
+	if got := unifiedDiff("a.php", []byte(before), []byte("<?php\nuse A;\nuse B;\n")); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a.php", []byte(before), []byte(before)); got != "" {
+		t.Errorf("diff of equal content = %q, want none", got)
+	}
+	got := unifiedDiff("a.php", []byte("<?php\nuse B;\nuse A;"), []byte("<?php\nuse A;\nuse B;"))
+	if !strings.Contains(got, "\\ No newline at end of file\n") {
+		t.Errorf("diff does not mark the missing final newline:\n%s", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runGit runs git, replaced by tests with a stub.
+var runGit = execGit
+
+// execGit runs git with args in the current directory and returns its
+// output. A missing git or a failing command, such as outside a repository,
+// is an error carrying git's own message.
+func execGit(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("git is not installed or not in PATH")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// gitPaths runs a git command listing NUL-delimited paths.
+func gitPaths(args ...string) ([]string, error) {
+	out, err := runGit(args...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// stagedFiles returns the files added, copied or modified in the index,
+// relative to the current directory. Like a directory walk in project mode,
+// only files below it are listed.
+func stagedFiles() ([]string, error) {
+	// Outside a repository git diff would compare paths instead
+	if _, err := runGit("rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, err
+	}
+	return gitPaths("diff", "--cached", "--name-only", "--diff-filter=ACM", "--relative", "-z")
+}
+
+// unstagedFiles returns the files of the working tree with changes that are
+// not staged, relative to the current directory.
+func unstagedFiles() ([]string, error) {
+	return gitPaths("diff", "--name-only", "--relative", "-z")
+}
+
+// restage adds the given files back to the index after they were sorted.
+func restage(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := runGit(append([]string{"add", "--"}, paths...)...)
+	return err
+}
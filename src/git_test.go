@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubGit replaces runGit for the test with one answering from outputs,
+// keyed by the git subcommand. Every invocation is recorded in calls.
+func stubGit(t *testing.T, outputs map[string]string, failing map[string]error) *[][]string {
+	t.Helper()
+	var calls [][]string
+	previous := runGit
+	t.Cleanup(func() { runGit = previous })
+	runGit = func(args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		if err, ok := failing[args[0]]; ok {
+			return nil, err
+		}
+		return []byte(outputs[args[0]]), nil
+	}
+	return &calls
+}
+
+func TestStagedFiles(t *testing.T) {
+	calls := stubGit(t, map[string]string{
+		"rev-parse": "true\n",
+		"diff":      "app/User.php\x00app/dir with space/Post.php\x00",
+	}, nil)
+	got, err := stagedFiles()
+	if err != nil {
+		t.Fatalf("stagedFiles: %v", err)
+	}
+	if want := []string{"app/User.php", "app/dir with space/Post.php"}; !slices.Equal(got, want) {
+		t.Errorf("stagedFiles = %q, want %q", got, want)
+	}
+	if len(*calls) != 2 || !slices.Contains((*calls)[1], "--cached") {
+		t.Errorf("git calls = %q, want rev-parse then diff --cached", *calls)
+	}
+}
+
+func TestStagedFilesOutsideRepository(t *testing.T) {
+	notRepo := errors.New("git rev-parse: fatal: not a git repository")
+	calls := stubGit(t, nil, map[string]error{"rev-parse": notRepo})
+	if _, err := stagedFiles(); !errors.Is(err, notRepo) {
+		t.Fatalf("stagedFiles: err = %v, want %v", err, notRepo)
+	}
+	// The diff is never run, which outside a repository compares paths
+	if len(*calls) != 1 {
+		t.Errorf("git calls = %q, want only rev-parse", *calls)
+	}
+}
+
+func TestRestage(t *testing.T) {
+	calls := stubGit(t, nil, nil)
+	if err := restage(nil); err != nil {
+		t.Fatalf("restage: %v", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("git calls = %q for no files, want none", *calls)
+	}
+	if err := restage([]string{"-x.php", "app/User.php"}); err != nil {
+		t.Fatalf("restage: %v", err)
+	}
+	if got := strings.Join((*calls)[0], " "); got != "add -- -x.php app/User.php" {
+		t.Errorf("git %s, want git add -- -x.php app/User.php", got)
+	}
+}
+
+func TestRestageFails(t *testing.T) {
+	failed := errors.New("git add: index.lock exists")
+	stubGit(t, nil, map[string]error{"add": failed})
+	if err := restage([]string{"app/User.php"}); !errors.Is(err, failed) {
+		t.Errorf("restage: err = %v, want %v", err, failed)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitignore applies the .gitignore files of the walked tree, and those of
+// its parents up to the repository root, with git's precedence: the rules of
+// a deeper file override those of its parents.
+type gitignore struct {
+	cwd string
+	// rules by the absolute directory of their .gitignore
+	rules map[string]ignoreRules
+}
+
+// loadGitignore reads the .gitignore files from dir up to the root of the git
+// repository containing it. Outside a repository only the files found while
+// walking apply.
+func loadGitignore(dir string) (*gitignore, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	g := &gitignore{cwd: cwd, rules: make(map[string]ignoreRules)}
+
+	abs := filepath.Join(cwd, dir)
+	var parents []string
+	for current := filepath.Dir(abs); ; current = filepath.Dir(current) {
+		parents = append(parents, current)
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			for _, parent := range parents {
+				if err := g.enter(parent); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	return g, g.enter(abs)
+}
+
+// enter reads the .gitignore of a directory about to be walked.
+func (g *gitignore) enter(dir string) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(g.cwd, dir)
+	}
+	rules, err := loadIgnoreFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		g.rules[dir] = rules
+	}
+	return nil
+}
+
+// ignores reports whether git ignores a path relative to the current
+// directory. The .git directory itself is always ignored.
+func (g *gitignore) ignores(path string, isDir bool) bool {
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+	abs := filepath.Join(g.cwd, path)
+	// The nearest .gitignore with a matching rule decides, and within a file
+	// the last matching rule
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if rules, ok := g.rules[dir]; ok {
+			rel, err := filepath.Rel(dir, abs)
+			if err == nil {
+				for i := len(rules) - 1; i >= 0; i-- {
+					if rules[i].matchesGit(rel, isDir) {
+						return !rules[i].negate
+					}
+				}
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// matchesGit reports whether a rule of a .gitignore matches a path relative
+// to the file's directory. Unanchored patterns match the last name only,
+// since the walk never enters an ignored directory.
+func (r ignoreRule) matchesGit(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		matched, err := filepath.Match(r.pattern, filepath.Base(path))
+		return err == nil && matched
+	}
+	return matchSegments(strings.Split(filepath.ToSlash(r.pattern), "/"), strings.Split(filepath.ToSlash(path), "/"))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isGlob reports whether a path argument contains wildcards to expand.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// expandGlob returns the files matching a pattern, sorted. Unlike
+// filepath.Glob, a `**` segment matches any number of directories, so
+// `src/**/*.php` finds PHP files at any depth below src, whatever the shell.
+func expandGlob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	// Walk from the longest leading part without wildcards
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	fixed := 0
+	for fixed < len(parts) && !isGlob(parts[fixed]) {
+		fixed++
+	}
+	root := strings.Join(parts[:fixed], "/")
+	if root == "" {
+		root = "."
+		if strings.HasPrefix(pattern, "/") {
+			root = "/"
+		}
+	}
+	root = filepath.FromSlash(root)
+
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if matchSegments(parts[fixed:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return matches, err
+}
+
+// matchPattern reports whether a relative path matches an include or exclude
+// pattern, where `**` matches any number of directories wherever it appears,
+// as in `**/*.php` or `src/**/Tests/*.php`.
+func matchPattern(pattern, path string) bool {
+	return matchSegments(strings.Split(filepath.ToSlash(pattern), "/"), strings.Split(filepath.ToSlash(path), "/"))
+}
+
+// matchSegments matches slash-separated path segments against pattern
+// segments, where a `**` segment matches zero or more path segments and the
+// others are filepath.Match patterns.
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if matched, err := filepath.Match(pattern[0], path[0]); err != nil || !matched {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.php", "Foo.php", true},
+		{"*.php", "app/Foo.php", false},
+		{"**/*.php", "Foo.php", true},
+		{"**/*.php", "app/deep/Foo.php", true},
+		{"src/**/Tests/*.php", "src/Tests/FooTest.php", true},
+		{"src/**/Tests/*.php", "src/Billing/Tests/FooTest.php", true},
+		{"src/**/Tests/*.php", "src/Billing/Invoices/Tests/FooTest.php", true},
+		{"src/**/Tests/*.php", "src/Billing/Tests/Unit/FooTest.php", false},
+		{"src/**/Tests/*.php", "lib/Billing/Tests/FooTest.php", false},
+		{"src/**/Tests/*.php", "src/Billing/Testsuite/FooTest.php", false},
+		{"src/**/*/Fixtures", "src/a/b/Fixtures", true},
+		{"src/**/*/Fixtures", "src/Fixtures", false},
+		{"a/**/**/b", "a/b", true},
+		{"a/**/**/b", "a/x/y/b", true},
+		{"src/**", "src/Foo.php", true},
+		{"src/**", "src", true},
+		{"src/**", "srcs/Foo.php", false},
+		{"[", "[", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/")); got != tt.want {
+				t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoubleStarIncludeExclude(t *testing.T) {
+	include := []string{"src/**/Tests/*.php"}
+	exclude := []string{"src/**/Fixtures"}
+	tests := []struct {
+		path               string
+		included, excluded bool
+	}{
+		{"src/Tests/FooTest.php", true, false},
+		{"src/Billing/Tests/FooTest.php", true, false},
+		{"src/Billing/Foo.php", false, false},
+		{"src/Fixtures/Tests/FooTest.php", true, true},
+		{"src/Billing/Fixtures/Tests/FooTest.php", true, true},
+		{"src/Billing/FixturesOld/Tests/FooTest.php", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := shouldInclude(tt.path, include); got != tt.included {
+				t.Errorf("shouldInclude = %v, want %v", got, tt.included)
+			}
+			if got := shouldExclude(tt.path, exclude); got != tt.excluded {
+				t.Errorf("shouldExclude = %v, want %v", got, tt.excluded)
+			}
+		})
+	}
+}
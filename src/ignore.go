@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is the gitignore-style file read from the directory of the
+// config, next to psort.json.
+const ignoreFileName = ".psortignore"
+
+// ignoreRule is one pattern of a .psortignore file.
+type ignoreRule struct {
+	pattern string
+	// negate re-includes paths matched by an earlier rule (`!pattern`)
+	negate bool
+	// dirOnly only matches directories and their contents (`pattern/`)
+	dirOnly bool
+	// anchored is set for patterns with a slash before the end, which a
+	// .gitignore matches against the whole path rather than any name in it
+	anchored bool
+}
+
+// ignoreRules are the rules of a .psortignore file, in file order.
+type ignoreRules []ignoreRule
+
+// loadIgnoreFile reads the rules of a .psortignore file. A missing file has
+// no rules. Blank lines and lines starting with `#` are skipped.
+func loadIgnoreFile(path string) (ignoreRules, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules ignoreRules
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		// Patterns are always relative to the file's directory
+		rule.pattern = filepath.FromSlash(strings.TrimPrefix(line, "/"))
+		if rule.pattern != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// ignores reports whether a path relative to the config directory is
+// ignored. Patterns match like exclude patterns, and the last matching rule
+// decides, so a later `!` rule re-includes a path.
+func (rules ignoreRules) ignores(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.matches(path, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir && !strings.HasPrefix(path, r.pattern+string(filepath.Separator)) {
+		return false
+	}
+	return matchesExclude(path, r.pattern)
+}
+
+// mayReinclude reports whether a negated rule could match a path below an
+// ignored directory, in which case the directory must still be walked.
+func (rules ignoreRules) mayReinclude(dir string) bool {
+	dirParts := strings.Split(dir, string(filepath.Separator))
+	for _, rule := range rules {
+		if !rule.negate {
+			continue
+		}
+		if strings.HasPrefix(rule.pattern, "**"+string(filepath.Separator)) {
+			return true
+		}
+		patternParts := strings.Split(rule.pattern, string(filepath.Separator))
+		if len(patternParts) <= len(dirParts) {
+			continue
+		}
+		below := true
+		for i, part := range dirParts {
+			if matched, err := filepath.Match(patternParts[i], part); err != nil || !matched {
+				below = false
+				break
+			}
+		}
+		if below {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// logLevel selects how much is printed about the files being processed.
+type logLevel int
+
+const (
+	// levelQuiet prints errors only (-q)
+	levelQuiet logLevel = iota
+	// levelNormal also prints each file that is rewritten
+	levelNormal
+	// levelVerbose also prints every file as it is processed (-v)
+	levelVerbose
+)
+
+// logger prints messages about the files processed by concurrent workers.
+// Each message is written whole, so lines of different files never
+// interleave.
+type logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+}
+
+// newLogger returns the logger for a run. Messages go to stderr when stdout
+// carries file lists or diffs, and nowhere in a JSON report, which includes
+// the errors itself.
+func newLogger(opts *Options) *logger {
+	l := &logger{w: os.Stdout, level: opts.logLevel()}
+	switch {
+	case opts.Format == "json":
+		l.w = io.Discard
+	case opts.readOnly() || opts.List:
+		l.w = os.Stderr
+	}
+	return l
+}
+
+func (l *logger) printf(level logLevel, format string, args ...interface{}) {
+	if l.level < level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+// errorf prints an error, whatever the level.
+func (l *logger) errorf(format string, args ...interface{}) {
+	l.printf(levelQuiet, "Error processing "+format, args...)
+}
+
+// infof prints an outcome worth knowing about, such as a rewritten file.
+func (l *logger) infof(format string, args ...interface{}) {
+	l.printf(levelNormal, format, args...)
+}
+
+// verbosef prints progress under -v.
+func (l *logger) verbosef(format string, args ...interface{}) {
+	l.printf(levelVerbose, format, args...)
+}
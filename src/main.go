@@ -1,348 +1,1185 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+
+	"psort/src/sorter"
 )
 
-type Config struct {
-	Include              []string `json:"include"`
-	Exclude              []string `json:"exclude"`
-	Groups               []string `json:"groups"`
-	NewlineBetweenGroups bool     `json:"newline_between_groups"`
+// Options holds the command line switches, on top of the sorter's options.
+type Options struct {
+	sorter.Options
+
+	// AtomicDir replaces the files of a directory only if all of them were
+	// sorted successfully.
+	AtomicDir bool
+	// Check compares files against their sorted form without writing them.
+	Check bool
+	// Write rewrites the files in config mode, which otherwise only lists
+	// the files that would change.
+	Write bool
+	// List prints the path of each file whose imports are not sorted, and
+	// only writes it together with Write.
+	List bool
+	// Diff prints a unified diff for each file that would change, without
+	// writing it.
+	Diff bool
+	// Audit reports import statistics for the project without writing.
+	Audit bool
+	// Jobs is the number of files processed at once, 0 to use the config's
+	// concurrency or the number of CPUs.
+	Jobs int
+	// FilesFrom0 is a file (or "-" for stdin) listing NUL-delimited paths to
+	// process instead of walking the directory tree.
+	FilesFrom0 string
+	// FromFile is like FilesFrom0 with one path per line, skipping blank
+	// lines and # comments. Paths not selected by the config's include and
+	// exclude patterns are skipped, unless NoFilter is set.
+	FromFile string
+	NoFilter bool
+	// Staged processes the files staged in git that the config selects,
+	// and Restage adds those it rewrote back to the index.
+	Staged  bool
+	Restage bool
+	// Format is "text" for messages meant for people, or "json" for a
+	// single JSON report once all files are processed.
+	Format string
+	// Quiet prints nothing but errors, ListAll every file processed rather
+	// than only those rewritten.
+	Quiet   bool
+	ListAll bool
+	// Cache skips the files recorded as sorted in .psortcache by an earlier
+	// run, and records those found or left sorted.
+	Cache bool
+	// Strict fails a project mode run that matches no files.
+	Strict bool
+	// Serial processes files one at a time in sorted path order, once all
+	// of them are known, so that the output is the same on every run.
+	Serial bool
+}
+
+// warnf prints a warning where the sorter prints its own.
+func (o *Options) warnf(format string, args ...interface{}) {
+	fmt.Fprintf(o.Warnings, "Warning: "+format+"\n", args...)
+}
+
+// logLevel returns the level selected by -q and -v.
+func (o *Options) logLevel() logLevel {
+	switch {
+	case o.Quiet:
+		return levelQuiet
+	case o.ListAll:
+		return levelVerbose
+	}
+	return levelNormal
+}
+
+// readOnly reports whether files are only compared against their sorted form.
+func (o *Options) readOnly() bool {
+	return o.Check || o.Diff || (o.List && !o.Write)
 }
 
+// concurrency returns how many files may be processed at once.
+func (o *Options) concurrency(config *sorter.Config) int {
+	if o.Jobs > 0 {
+		return o.Jobs
+	}
+	if config.Concurrency > 0 {
+		return config.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// serial reports whether files are processed one at a time in path order,
+// as with -serial or a concurrency of 1.
+func (o *Options) serial(config *sorter.Config) bool {
+	return o.Serial || o.concurrency(config) == 1
+}
+
+// Exit statuses, distinguishing a broken config from files that failed.
+const (
+	// exitFailure means a file could not be processed, or is not sorted
+	// under --check
+	exitFailure = 1
+	// exitConfigError means the flags or the config are invalid
+	exitConfigError = 2
+)
+
 func main() {
-	if len(os.Args) > 1 {
+	// Warnings go to stdout unless it carries sorted output, file lists,
+	// diffs or a report, and nowhere under -q
+	opts := &Options{Options: sorter.Options{Warnings: os.Stdout}}
+	flag.BoolVar(&opts.SafeWrite, "safe-write", false, "skip files that change on disk while being sorted")
+	flag.BoolVar(&opts.Debug, "debug", false, "log why each blank line in an import block is inserted, and how many lines moved")
+	flag.BoolVar(&opts.Debug, "verbose", false, "same as -debug")
+	flag.BoolVar(&opts.Backup, "backup", false, "save the original of each modified file with a .bak suffix (or backup_suffix)")
+	flag.BoolVar(&opts.AtomicDir, "atomic-dir", false, "write each directory's files all-or-nothing")
+	flag.BoolVar(&opts.Converge, "converge", false, "re-sort each result until stable and warn if it keeps changing")
+	flag.BoolVar(&opts.ReportUnused, "report-unused", false, "warn about imports whose name does not appear in the rest of the file")
+	flag.BoolVar(&opts.Verify, "verify", false, "re-sort each result once and fail, leaving the file untouched, if that changes it")
+	flag.BoolVar(&opts.Check, "check", false, "list files whose imports are not sorted and exit with status 1, without modifying them")
+	flag.BoolVar(&opts.Write, "w", false, "rewrite files in project mode instead of listing those that would change")
+	flag.BoolVar(&opts.List, "l", false, "list files whose imports are not sorted, without modifying them unless -w is given")
+	flag.BoolVar(&opts.Diff, "diff", false, "print a unified diff of the changes instead of modifying files")
+	flag.BoolVar(&opts.Audit, "audit", false, "report import statistics for the project without modifying any file")
+	flag.BoolVar(&opts.Strict, "strict", false, "exit with status 1 if the include patterns match no files")
+	flag.BoolVar(&opts.Serial, "serial", false, "process files one at a time in sorted path order, for reproducible output (implied by -j 1)")
+	flag.IntVar(&opts.Jobs, "j", 0, "process at most `n` files at once (default: concurrency from the config, or the number of CPUs)")
+	flag.StringVar(&opts.FilesFrom0, "files-from0", "", "process the NUL-delimited paths listed in `file` (\"-\" for stdin)")
+	flag.StringVar(&opts.FromFile, "from-file", "", "process the paths listed one per line in `file` (\"-\" for stdin)")
+	flag.BoolVar(&opts.Staged, "staged", false, "process the files staged in git instead of walking the directory tree")
+	flag.BoolVar(&opts.Restage, "restage", false, "with -staged, git add the files that were rewritten")
+	flag.BoolVar(&opts.NoFilter, "no-filter", false, "with -from-file, process every listed path, ignoring include and exclude")
+	flag.BoolVar(&opts.Cache, "cache", false, "skip files unchanged since .psortcache recorded them as sorted")
+	flag.BoolVar(&opts.Quiet, "q", false, "print nothing but errors")
+	flag.BoolVar(&opts.ListAll, "v", false, "print every file processed, not only those rewritten")
+	flag.StringVar(&opts.Format, "format", "text", "print results as `format`: text or json")
+	stdinPath := flag.String("stdin-filepath", "", "resolve the config and include/exclude patterns as if stdin were read from `path`")
+	lineRange := flag.String("range", "", "in filter mode, only sort the use blocks overlapping lines `start:end`")
+	profile := flag.String("profile", "", "apply the options of profile `name` from the config's profiles")
+	configPath := flag.String("config", "", "read the config from `path` instead of looking for psort.json")
+	explain := flag.String("explain", "", "print how `import` is grouped and sorted, without processing files")
+	flag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "j" && opts.Jobs < 1 {
+			fmt.Println("Error: -j must be at least 1")
+			os.Exit(exitConfigError)
+		}
+	})
+	if opts.Quiet && opts.ListAll {
+		fmt.Println("Error: -q and -v cannot be combined")
+		os.Exit(exitConfigError)
+	}
+	if opts.FilesFrom0 != "" && opts.FromFile != "" {
+		fmt.Println("Error: -files-from0 and -from-file cannot be combined")
+		os.Exit(exitConfigError)
+	}
+	if opts.Staged && (opts.FilesFrom0 != "" || opts.FromFile != "") {
+		fmt.Println("Error: -staged cannot be combined with -files-from0 or -from-file")
+		os.Exit(exitConfigError)
+	}
+	if opts.Restage && !opts.Staged {
+		fmt.Println("Error: -restage only applies to -staged")
+		os.Exit(exitConfigError)
+	}
+	if opts.NoFilter && opts.FromFile == "" {
+		fmt.Println("Error: -no-filter only applies to -from-file")
+		os.Exit(exitConfigError)
+	}
+	if opts.readOnly() || opts.List {
+		opts.Warnings = os.Stderr
+	}
+	if opts.Quiet {
+		opts.Warnings = io.Discard
+	}
+	switch opts.Format {
+	case "text":
+	case "json":
+		// Keep stdout for the report
+		opts.Warnings = os.Stderr
+	default:
+		fmt.Printf("Error: unknown -format %q (allowed: text, json)\n", opts.Format)
+		os.Exit(exitConfigError)
+	}
+
+	if *lineRange != "" {
+		if flag.NArg() != 1 || flag.Arg(0) != "-" {
+			fmt.Println("Error: -range only applies to filter mode (psort -)")
+			os.Exit(exitConfigError)
+		}
+		r, err := parseRange(*lineRange)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		opts.Range = r
+	}
+
+	if *explain != "" {
+		config, err := findConfig(*configPath, *profile, ".", false)
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		sorter.Explain(os.Stdout, *explain, config)
+		return
+	}
+
+	if opts.Staged {
+		// Staged mode
+		paths, err := stagedFiles()
+		if err != nil {
+			fmt.Printf("Error listing staged files: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		config, err := findConfig(*configPath, *profile, ".", false)
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		if paths, err = selectedPaths(config, paths); err != nil {
+			fmt.Printf("Error listing staged files: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		runStaged(paths, config, opts)
+		return
+	}
+
+	if opts.FilesFrom0 != "" || opts.FromFile != "" {
+		// File list mode
+		var paths []string
+		var err error
+		if opts.FromFile != "" {
+			paths, err = readPathList(opts.FromFile)
+		} else {
+			paths, err = readPathList0(opts.FilesFrom0)
+		}
+		if err != nil {
+			fmt.Printf("Error reading file list: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		config, err := findConfig(*configPath, *profile, ".", false)
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		if opts.FromFile != "" && !opts.NoFilter {
+			if paths, err = selectedPaths(config, paths); err != nil {
+				fmt.Printf("Error reading file list: %v\n", err)
+				os.Exit(exitFailure)
+			}
+		}
+
+		runPaths(paths, config, opts)
+		return
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "-" {
+		// Filter mode: sort stdin to stdout, e.g. for editor integration
+		dir, name := ".", "<stdin>"
+		if *stdinPath != "" {
+			dir, name = filepath.Dir(*stdinPath), *stdinPath
+		}
+		config, err := findConfig(*configPath, *profile, dir, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		opts.Warnings = os.Stderr
+		selected := true
+		if *stdinPath != "" {
+			if selected, err = selectsPath(config, *stdinPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error processing stdin: %v\n", err)
+				os.Exit(exitFailure)
+			}
+		}
+		if !selected {
+			// Not a file project mode would sort, pass it through
+			_, err = io.Copy(os.Stdout, os.Stdin)
+		} else {
+			err = sortStdin(config, name, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing stdin: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	if flag.NArg() > 1 || (flag.NArg() == 1 && isGlob(flag.Arg(0))) {
+		// Several paths or globs, processed like a file list
+		var paths []string
+		for _, arg := range flag.Args() {
+			if !isGlob(arg) {
+				paths = append(paths, arg)
+				continue
+			}
+			matches, err := expandGlob(arg)
+			if err != nil {
+				fmt.Printf("Error expanding %s: %v\n", arg, err)
+				os.Exit(exitFailure)
+			}
+			if len(matches) == 0 {
+				opts.warnf("no files match %s", arg)
+			}
+			paths = append(paths, matches...)
+		}
+		config, err := findConfig(*configPath, *profile, ".", false)
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
+		}
+		runPaths(paths, config, opts)
+		return
+	}
+
+	if flag.NArg() > 0 {
 		// Single file mode
-		filePath := os.Args[1]
+		filePath := flag.Arg(0)
 		// We need to load config even in single file mode to get groups if available
 		// Or we just use default if not found.
-		// For now, let's try to load config if it exists, otherwise default.
-		config, _ := loadConfig("psort.json")
-		if config == nil {
-			config = &Config{}
+		config, err := findConfig(*configPath, *profile, filepath.Dir(filePath), false)
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(exitConfigError)
 		}
-		if err := processFile(filePath, config); err != nil {
+		if opts.Format == "json" {
+			runPaths([]string{filePath}, config, opts)
+			return
+		}
+		changed, _, err := processFile(filePath, config, opts)
+		if err != nil {
+			// A skipped write is a warning, but still an error under -q
+			if errors.Is(err, sorter.ErrChangedOnDisk) && !opts.Quiet {
+				opts.warnf("%s: %v", filePath, err)
+				os.Exit(exitFailure)
+			}
 			fmt.Printf("Error processing file: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitFailure)
+		}
+		if opts.Check {
+			if changed {
+				exitForCheck([]string{filePath}, opts)
+			}
+			if !opts.Quiet {
+				fmt.Printf("Imports in %s are sorted\n", filePath)
+			}
+			return
+		}
+		if opts.List {
+			if changed {
+				fmt.Println(filePath)
+			}
+			return
+		}
+		if opts.Diff {
+			return
+		}
+		if opts.Quiet {
+			return
+		}
+		if changed {
+			fmt.Printf("Successfully sorted imports in %s\n", filePath)
+		} else {
+			fmt.Printf("Imports in %s are already sorted\n", filePath)
 		}
-		fmt.Printf("Successfully sorted imports in %s\n", filePath)
 		return
 	}
 
 	// Config mode
-	config, err := loadConfig("psort.json")
+	config, err := findConfig(*configPath, *profile, ".", true)
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
-	var wg sync.WaitGroup
-	// Semaphore to limit concurrency (e.g., 100 concurrent files)
-	sem := make(chan struct{}, 100)
+	if opts.Audit {
+		if err := runAudit(os.Stdout, config, opts); err != nil {
+			fmt.Printf("Error walking directory: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	// Without -w, only list what would change so a first run is harmless
+	listOnly := !opts.Write && !opts.Check && !opts.Diff
+	if listOnly {
+		opts.List = true
+		if !opts.Quiet {
+			opts.Warnings = os.Stderr
+		}
+	}
+
+	r := newRunner(config, opts)
+	err = walkIncluded(config, r.add)
+	changed := r.wait()
+
+	if err != nil {
+		fmt.Printf("Error walking directory: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if len(r.scanned) == 0 {
+		// Most likely a typo in the include patterns
+		patterns := config.Include
+		if len(patterns) == 0 {
+			patterns = defaultInclude
+		}
+		opts.warnf("no files matched the include patterns %s", strings.Join(patterns, ", "))
+		if opts.Strict {
+			os.Exit(exitFailure)
+		}
+	}
+	r.report(changed, listOnly)
+}
+
+// runPaths processes an explicit list of files, then reports and exits like
+// project mode.
+func runPaths(paths []string, config *sorter.Config, opts *Options) {
+	r := newRunner(config, opts)
+	for _, path := range paths {
+		r.add(path)
+	}
+	r.report(r.wait(), false)
+}
+
+// runStaged processes the staged files like runPaths and, under Restage,
+// adds those it rewrote back to the index. A file that also has unstaged
+// changes is not re-added, as that would stage them too.
+func runStaged(paths []string, config *sorter.Config, opts *Options) {
+	var unstaged []string
+	if opts.Restage && !opts.readOnly() {
+		var err error
+		if unstaged, err = unstagedFiles(); err != nil {
+			fmt.Printf("Error listing unstaged changes: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	r := newRunner(config, opts)
+	for _, path := range paths {
+		r.add(path)
+	}
+	changed := r.wait()
+	if opts.Restage && !opts.readOnly() {
+		var sorted []string
+		for _, path := range changed {
+			if slices.Contains(unstaged, path) {
+				opts.warnf("%s has unstaged changes, sorted but not re-added", path)
+				continue
+			}
+			sorted = append(sorted, path)
+		}
+		if err := restage(sorted); err != nil {
+			fmt.Printf("Error re-adding sorted files: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+	r.report(changed, false)
+}
 
-	err = filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+// printChanged prints the paths of the changed files under -l.
+func printChanged(changed []string, opts *Options) {
+	if !opts.List {
+		return
+	}
+	for _, path := range changed {
+		fmt.Println(path)
+	}
+}
+
+// exitForCheck lists the files whose imports are not sorted and exits with
+// status 1 under --check. Otherwise it does nothing.
+func exitForCheck(changed []string, opts *Options) {
+	if !opts.Check || len(changed) == 0 {
+		return
+	}
+	fmt.Println("Imports are not sorted in:")
+	for _, path := range changed {
+		fmt.Println(path)
+	}
+	os.Exit(exitFailure)
+}
+
+// walkIncluded walks the current directory and calls fn for every file
+// selected by the include and exclude patterns and not ignored by
+// .psortignore or, unless disabled, .gitignore. Excluded directories are
+// pruned. Patterns are matched against paths relative to the config file's
+// directory, which may be a parent of the current one.
+func walkIncluded(config *sorter.Config, fn func(path string)) error {
+	base, err := relativeToRoot(config.Root)
+	if err != nil {
+		return err
+	}
+	ignore, err := loadIgnoreFile(filepath.Join(config.Root, ignoreFileName))
+	if err != nil {
+		return err
+	}
+	var git *gitignore
+	if config.RespectGitignore == nil || *config.RespectGitignore {
+		if git, err = loadGitignore("."); err != nil {
+			return err
+		}
+	}
+	return filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		rel := filepath.Join(base, path)
+		if git != nil && path != "." && git.ignores(path, d.IsDir()) {
+			// Git never looks inside an ignored directory either
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		// Skip directories but check for exclusion first to prune
 		if d.IsDir() {
-			if shouldExclude(path, config.Exclude) {
+			if shouldExclude(rel, config.Exclude) {
+				return filepath.SkipDir
+			}
+			if rel != "." && ignore.ignores(rel, true) && !ignore.mayReinclude(rel) {
 				return filepath.SkipDir
 			}
+			if git != nil && path != "." {
+				return git.enter(path)
+			}
 			return nil
 		}
 
-		if shouldExclude(path, config.Exclude) {
+		if shouldExclude(rel, config.Exclude) || ignore.ignores(rel, false) {
 			return nil
 		}
 
-		if shouldInclude(path, config.Include) {
-			wg.Add(1)
-			sem <- struct{}{} // Acquire token
-			go func(p string) {
-				defer wg.Done()
-				defer func() { <-sem }() // Release token
-
-				fmt.Printf("Processing %s...\n", p)
-				if err := processFile(p, config); err != nil {
-					fmt.Printf("Error processing %s: %v\n", p, err)
-				}
-			}(path)
+		if shouldInclude(rel, config.Include) {
+			fn(path)
 		}
 
 		return nil
 	})
+}
+
+// auditTotals aggregates the import hygiene of a project for --audit.
+type auditTotals struct {
+	mu          sync.Mutex
+	files       int
+	withImports int
+	sorted      int
+	wouldChange int
+	imports     int
+	errors      int
+}
+
+// runAudit sorts every included file in memory and prints a summary of how
+// many would change. It never writes to any file.
+func runAudit(w io.Writer, config *sorter.Config, opts *Options) error {
+	// Per-file diagnostics would drown the summary
+	quiet := opts.Options
+	quiet.Debug = false
+	quiet.Warnings = io.Discard
+
+	var totals auditTotals
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.concurrency(config))
+
+	err := walkIncluded(config, func(path string) {
+		wg.Add(1)
+		sem <- struct{}{} // Acquire token
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }() // Release token
+
+			original, err := os.ReadFile(path)
+			var result sorter.Result
+			if err == nil {
+				result, err = sorter.Sort(original, path, config, &quiet)
+			}
 
+			totals.mu.Lock()
+			defer totals.mu.Unlock()
+			totals.files++
+			if err != nil {
+				fmt.Fprintf(w, "Error processing %s: %v\n", path, err)
+				totals.errors++
+				return
+			}
+			if result.Imports > 0 {
+				totals.withImports++
+			}
+			if bytes.Equal(original, result.Output) {
+				totals.sorted++
+			} else {
+				totals.wouldChange++
+			}
+			totals.imports += result.Imports
+		}()
+	})
+	wg.Wait()
 	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
-	wg.Wait()
+	fmt.Fprintf(w, "Files scanned:      %d\n", totals.files)
+	fmt.Fprintf(w, "Files with imports: %d\n", totals.withImports)
+	fmt.Fprintf(w, "Already sorted:     %d\n", totals.sorted)
+	fmt.Fprintf(w, "Would change:       %d\n", totals.wouldChange)
+	fmt.Fprintf(w, "Total imports:      %d\n", totals.imports)
+	if totals.errors > 0 {
+		fmt.Fprintf(w, "Errors:             %d\n", totals.errors)
+	}
+	return nil
 }
 
-func loadConfig(path string) (*Config, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+// runner processes files concurrently and collects their outcome.
+type runner struct {
+	config *sorter.Config
+	opts   *Options
+	log    *logger
+	// cache is nil without --cache
+	cache *cache
 
-	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return nil, err
+	wg sync.WaitGroup
+	// Semaphore to limit concurrency, see Options.concurrency
+	sem chan struct{}
+
+	// Paths by directory for --atomic-dir
+	dirs     []string
+	dirPaths map[string][]string
+	// serial holds files back until wait, in pending, to process them in
+	// path order, see Options.serial
+	serial  bool
+	pending []string
+
+	mu      sync.Mutex
+	changed []string
+	// moved counts the import lines moved in each changed file
+	moved map[string]int
+	// scanned are the files processed, failed the errors of those that
+	// could not be sorted
+	scanned []string
+	failed  map[string]error
+}
+
+func newRunner(config *sorter.Config, opts *Options) *runner {
+	var c *cache
+	if opts.Cache {
+		c = loadCache(config.Root, cacheFingerprint(config, opts))
+	}
+	return &runner{
+		cache:    c,
+		config:   config,
+		opts:     opts,
+		log:      newLogger(opts),
+		sem:      make(chan struct{}, opts.concurrency(config)),
+		serial:   opts.serial(config),
+		dirPaths: make(map[string][]string),
+		moved:    make(map[string]int),
+		failed:   make(map[string]error),
 	}
-	return &config, nil
 }
 
-func shouldExclude(path string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Check for recursive pattern
-		if strings.HasPrefix(pattern, "**/") {
-			basePattern := strings.TrimPrefix(pattern, "**/")
-			matched, err := filepath.Match(basePattern, filepath.Base(path))
-			if err == nil && matched {
-				return true
-			}
-			continue
+// add schedules a file for processing. Under --atomic-dir, files are held
+// back until wait so each directory can be written as a whole.
+func (r *runner) add(path string) {
+	if r.opts.AtomicDir && !r.opts.readOnly() {
+		dir := filepath.Dir(path)
+		if _, ok := r.dirPaths[dir]; !ok {
+			r.dirs = append(r.dirs, dir)
 		}
+		r.dirPaths[dir] = append(r.dirPaths[dir], path)
+		return
+	}
+	if r.serial {
+		r.pending = append(r.pending, path)
+		return
+	}
+	r.goProcess(func() { r.processFile(path) })
+}
 
-		// Strict match against full relative path
-		matched, err := filepath.Match(pattern, path)
-		if err == nil && matched {
-			return true
+// wait processes any directory batches and blocks until all files are done.
+// It returns the sorted paths of the files that changed.
+func (r *runner) wait() []string {
+	if r.serial {
+		sort.Strings(r.pending)
+		for _, path := range r.pending {
+			r.processFile(path)
 		}
-
-		// Also check if path starts with pattern (directory exclusion)
-		// e.g. exclude "vendor" should match "vendor/foo/bar.php"
-		if strings.HasPrefix(path, pattern+string(filepath.Separator)) || path == pattern {
-			return true
+		sort.Strings(r.dirs)
+		for _, dir := range r.dirs {
+			sort.Strings(r.dirPaths[dir])
+			r.processDir(dir, r.dirPaths[dir])
+		}
+	} else {
+		for _, dir := range r.dirs {
+			dir, paths := dir, r.dirPaths[dir]
+			r.goProcess(func() { r.processDir(dir, paths) })
 		}
 	}
-	return false
+	r.wg.Wait()
+	if r.cache != nil {
+		if err := r.cache.save(); err != nil {
+			r.log.errorf("%s: %v", r.cache.path, err)
+		}
+	}
+	sort.Strings(r.changed)
+	return r.changed
 }
 
-func shouldInclude(path string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Check for recursive pattern
-		if strings.HasPrefix(pattern, "**/") {
-			basePattern := strings.TrimPrefix(pattern, "**/")
-			matched, err := filepath.Match(basePattern, filepath.Base(path))
-			if err == nil && matched {
-				return true
-			}
-			continue
-		}
+// goProcess runs fn in a new goroutine, holding a token from sem for the
+// duration so that at most cap(sem) files are processed at once.
+func (r *runner) goProcess(fn func()) {
+	r.wg.Add(1)
+	r.sem <- struct{}{} // Acquire token
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.sem }() // Release token
+		fn()
+	}()
+}
 
-		// Strict match against full relative path
-		matched, err := filepath.Match(pattern, path)
-		if err == nil && matched {
-			return true
-		}
+// recordSorted adds a processed file to the cache if it is now sorted on
+// disk: it was already, or it was rewritten.
+func (r *runner) recordSorted(path string, changed bool) {
+	if r.cache != nil && (!changed || !r.opts.readOnly()) {
+		r.cache.record(path)
 	}
-	return false
 }
 
-func processFile(filePath string, config *Config) error {
-	// Open original file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
+// logChanged reports a rewritten file. Files that would change are already
+// listed or diffed when not writing.
+func (r *runner) logChanged(path string) {
+	if !r.opts.readOnly() && !r.opts.List {
+		r.log.infof("Sorted imports in %s", path)
 	}
-	defer file.Close()
+}
 
-	// Get file info to preserve permissions
-	info, err := file.Stat()
-	if err != nil {
-		return err
+func (r *runner) recordChanged(path string, moved int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed = append(r.changed, path)
+	r.moved[path] = moved
+}
+
+func (r *runner) recordScanned(paths ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scanned = append(r.scanned, paths...)
+}
+
+func (r *runner) recordFailed(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed[path] = err
+}
+
+// report prints the outcome of a run once wait has returned, followed by a
+// hint to rerun with -w if asked, and exits like exitForCheck and
+// exitForFailures.
+func (r *runner) report(changed []string, hint bool) {
+	if r.opts.Format == "json" {
+		if err := r.printJSON(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if r.opts.Check && len(changed) > 0 {
+			os.Exit(exitFailure)
+		}
+		r.exitForFailures()
+		return
+	}
+	exitForCheck(changed, r.opts)
+	printChanged(changed, r.opts)
+	if r.opts.Quiet {
+		r.exitForFailures()
+		return
+	}
+	r.printSummary()
+	if hint && len(changed) > 0 {
+		fmt.Fprintln(os.Stderr, "Run with -w to sort them")
 	}
-	mode := info.Mode()
+	r.exitForFailures()
+}
 
-	// Create temp file
-	tempFile, err := os.CreateTemp("", "php_sort_*.php")
-	if err != nil {
-		return err
+// exitForFailures exits with exitFailure if any file failed to process.
+func (r *runner) exitForFailures() {
+	if len(r.failed) > 0 {
+		os.Exit(exitFailure)
 	}
-	tempPath := tempFile.Name()
-	// Ensure temp file is cleaned up if we error out before rename
-	defer func() {
-		tempFile.Close()
-		if _, err := os.Stat(tempPath); err == nil {
-			os.Remove(tempPath)
-		}
-	}()
+}
 
-	writer := bufio.NewWriter(tempFile)
-	scanner := bufio.NewScanner(file)
+// printSummary reports the totals of a run once wait has returned. Under
+// -l or --diff it goes to stderr, keeping stdout for the paths or diffs.
+func (r *runner) printSummary() {
+	w := io.Writer(os.Stdout)
+	if r.opts.readOnly() {
+		w = os.Stderr
+	}
+	verb := "changed"
+	if r.opts.readOnly() {
+		verb = "would change"
+	}
+	fmt.Fprintf(w, "Scanned %d files: %d %s, %d failed\n", len(r.scanned), len(r.changed), verb, len(r.failed))
+	if len(r.failed) > 0 {
+		var failures []string
+		for path, err := range r.failed {
+			failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+		}
+		sort.Strings(failures)
+		fmt.Fprintln(w, "Failed:")
+		for _, failure := range failures {
+			fmt.Fprintf(w, "  %s\n", failure)
+		}
+	}
+}
 
-	var useBlock []string
-	var pendingEmptyLines []string
-	inUseBlock := false
+// fileReport is the outcome of one file in the -format json report. Changed
+// means "would change" when files are not written.
+type fileReport struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+	Moved   int    `json:"moved"`
+	Error   string `json:"error,omitempty"`
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		isUse := strings.HasPrefix(trimmed, "use ") && strings.HasSuffix(trimmed, ";")
-		isEmpty := trimmed == ""
+// summaryReport holds the totals of the -format json report.
+type summaryReport struct {
+	Scanned int `json:"scanned"`
+	Changed int `json:"changed"`
+	Failed  int `json:"failed"`
+	Moved   int `json:"moved"`
+}
 
-		if isUse {
-			if !inUseBlock {
-				inUseBlock = true
-			}
-			// If we were tracking empty lines within a use block, discard them (consolidate)
-			pendingEmptyLines = []string{}
-			useBlock = append(useBlock, line)
-		} else if isEmpty {
-			if inUseBlock {
-				// Buffer empty lines while in a use block
-				pendingEmptyLines = append(pendingEmptyLines, line)
-			} else {
-				// Not in use block, write immediately
-				if _, err := writer.WriteString(line + "\n"); err != nil {
-					return err
-				}
-			}
-		} else {
-			if inUseBlock {
-				// End of use block
-				if err := writeSortedBlock(writer, useBlock, config); err != nil {
-					return err
-				}
-				// Write any pending empty lines that came after the last use statement
-				for _, emptyLine := range pendingEmptyLines {
-					if _, err := writer.WriteString(emptyLine + "\n"); err != nil {
-						return err
-					}
-				}
+// printJSON writes the outcome of every scanned file, sorted by path, and
+// the totals of the run as one JSON object.
+func (r *runner) printJSON(w io.Writer) error {
+	changed := make(map[string]bool, len(r.changed))
+	for _, path := range r.changed {
+		changed[path] = true
+	}
+	scanned := append([]string(nil), r.scanned...)
+	sort.Strings(scanned)
 
-				useBlock = []string{}
-				pendingEmptyLines = []string{}
-				inUseBlock = false
-			}
-			if _, err := writer.WriteString(line + "\n"); err != nil {
-				return err
-			}
+	report := struct {
+		Files   []fileReport  `json:"files"`
+		Summary summaryReport `json:"summary"`
+	}{
+		Files:   make([]fileReport, 0, len(scanned)),
+		Summary: summaryReport{Scanned: len(scanned), Changed: len(r.changed), Failed: len(r.failed)},
+	}
+	for _, path := range scanned {
+		file := fileReport{Path: path, Changed: changed[path], Moved: r.moved[path]}
+		report.Summary.Moved += file.Moved
+		if err := r.failed[path]; err != nil {
+			file.Error = err.Error()
 		}
+		report.Files = append(report.Files, file)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (r *runner) processFile(path string) {
+	r.recordScanned(path)
+	if r.cache != nil && r.cache.sorted(path) {
+		r.log.verbosef("Skipping %s, sorted when cached", path)
+		return
+	}
+	r.log.verbosef("Processing %s...", path)
+	changed, moved, err := processFile(path, r.config, r.opts)
+	if err != nil {
+		r.recordFailed(path, err)
+		if errors.Is(err, sorter.ErrChangedOnDisk) && !r.opts.Quiet {
+			r.opts.warnf("%s: %v", path, err)
+			return
+		}
+		r.log.errorf("%s: %v", path, err)
+		return
+	}
+	if changed {
+		r.recordChanged(path, moved)
+		r.logChanged(path)
 	}
+	r.recordSorted(path, changed)
+}
 
-	// Flush remaining if file ends with use block
-	if inUseBlock {
-		if err := writeSortedBlock(writer, useBlock, config); err != nil {
-			return err
+// processDir sorts a directory's files and only replaces them if every file
+// in the directory could be sorted.
+func (r *runner) processDir(dir string, paths []string) {
+	var staged []*sorter.StagedFile
+	defer func() {
+		for _, s := range staged {
+			s.Discard()
+		}
+	}()
+
+	r.recordScanned(paths...)
+	for _, path := range paths {
+		if r.cache != nil && r.cache.sorted(path) {
+			r.log.verbosef("Skipping %s, sorted when cached", path)
+			continue
+		}
+		r.log.verbosef("Processing %s...", path)
+		s, err := sorter.Prepare(path, r.config, &r.opts.Options)
+		if err != nil {
+			r.recordFailed(path, err)
+			r.log.errorf("%s: %v (leaving %s unchanged)", path, err, dir)
+			return
 		}
-		// Write any pending empty lines at EOF
-		for _, emptyLine := range pendingEmptyLines {
-			if _, err := writer.WriteString(emptyLine + "\n"); err != nil {
-				return err
+		staged = append(staged, s)
+	}
+	if r.opts.SafeWrite {
+		for _, s := range staged {
+			if err := s.CheckUnchanged(); err != nil {
+				r.recordFailed(s.Path, err)
+				if r.opts.Quiet {
+					r.log.errorf("%s: %v (leaving %s unchanged)", s.Path, err, dir)
+				} else {
+					r.opts.warnf("%s: %v (leaving %s unchanged)", s.Path, err, dir)
+				}
+				return
 			}
 		}
 	}
+	for _, s := range staged {
+		if err := s.Commit(); err != nil {
+			r.recordFailed(s.Path, err)
+			r.log.errorf("%s: %v", s.Path, err)
+			continue
+		}
+		if s.Changed {
+			r.recordChanged(s.Path, s.Moved)
+			r.logChanged(s.Path)
+		}
+		r.recordSorted(s.Path, s.Changed)
+	}
+}
 
-	if err := writer.Flush(); err != nil {
-		return err
+// readPathList0 reads NUL-delimited paths, as produced by `find -print0` or
+// `git ls-files -z`, from a file or from stdin when name is "-".
+func readPathList0(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Close files before renaming
-	tempFile.Close()
-	file.Close()
+	var paths []string
+	for _, path := range strings.Split(string(data), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
 
-	// Preserve permissions
-	if err := os.Chmod(tempPath, mode); err != nil {
-		return err
+// readPathList reads the paths listed one per line in a file, or stdin for
+// "-". Blank lines and lines starting with # are skipped.
+func readPathList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Replace original file
-	return os.Rename(tempPath, filePath)
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		path := strings.TrimSpace(line)
+		if path != "" && !strings.HasPrefix(path, "#") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
 }
 
-func writeSortedBlock(w *bufio.Writer, block []string, config *Config) error {
-	groups := config.Groups
-	sort.Slice(block, func(i, j int) bool {
-		lineI := strings.TrimSpace(block[i])
-		lineJ := strings.TrimSpace(block[j])
+// relativeToRoot returns the current directory relative to root, "." when
+// they are the same.
+func relativeToRoot(root string) (string, error) {
+	if root == "" || root == "." {
+		return ".", nil
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absRoot, cwd)
+}
 
-		// Extract import path (remove "use " and ";")
-		importI := strings.TrimSuffix(strings.TrimPrefix(lineI, "use "), ";")
-		importJ := strings.TrimSuffix(strings.TrimPrefix(lineJ, "use "), ";")
+// findConfig loads the config given with -config, which must exist, or else
+// the one discovered from dir. A missing discovered config falls back to the
+// defaults unless required. The patterns of an explicit config are relative
+// to the current directory. A non-empty profile is applied over the
+// top-level options.
+func findConfig(explicit, profile, dir string, required bool) (*sorter.Config, error) {
+	if explicit != "" {
+		config, err := sorter.LoadProfile(explicit, profile)
+		if err != nil {
+			return nil, err
+		}
+		config.Root = "."
+		return config, nil
+	}
+	config, err := sorter.LoadProfile(sorter.DiscoverConfig(dir), profile)
+	if !required && errors.Is(err, os.ErrNotExist) {
+		return &sorter.Config{}, nil
+	}
+	return config, err
+}
 
-		groupI := getGroupIndex(importI, groups)
-		groupJ := getGroupIndex(importJ, groups)
+// selectsPath reports whether a file, whose path need not exist, is selected
+// by the config's include and exclude patterns and .psortignore, as a walk in
+// project mode would.
+func selectsPath(config *sorter.Config, path string) (bool, error) {
+	root, err := filepath.Abs(config.Root)
+	if err != nil {
+		return false, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return false, err
+	}
+	ignore, err := loadIgnoreFile(filepath.Join(config.Root, ignoreFileName))
+	if err != nil {
+		return false, err
+	}
+	if shouldExclude(rel, config.Exclude) || ignore.ignores(rel, false) {
+		return false, nil
+	}
+	return shouldInclude(rel, config.Include), nil
+}
 
-		if groupI != groupJ {
-			return groupI < groupJ
+// selectedPaths returns the paths selected by the config, see selectsPath.
+func selectedPaths(config *sorter.Config, paths []string) ([]string, error) {
+	var selected []string
+	for _, path := range paths {
+		ok, err := selectsPath(config, path)
+		if err != nil {
+			return nil, err
 		}
-		return lineI < lineJ
-	})
+		if ok {
+			selected = append(selected, path)
+		}
+	}
+	return selected, nil
+}
 
-	var lastGroup int = -1
-	if len(block) > 0 {
-		lastGroup = getGroupIndex(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(block[0]), "use "), ";"), groups)
+func shouldExclude(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesExclude(path, pattern) {
+			return true
+		}
 	}
+	return false
+}
 
-	for i, line := range block {
-		if i > 0 && len(groups) > 0 {
-			currentImport := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), "use "), ";")
-			currentGroup := getGroupIndex(currentImport, groups)
-			if currentGroup != lastGroup {
-				if config.NewlineBetweenGroups {
-					if _, err := w.WriteString("\n"); err != nil {
-						return err
-					}
-				}
-				lastGroup = currentGroup
-			}
+// matchesExclude reports whether a path matches one exclude pattern, either
+// itself or through one of its parent directories. Only whole segments
+// count, so `vendor` and `build/*` exclude everything below them while `app`
+// does not exclude `app.php`.
+func matchesExclude(path, pattern string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	for current := path; current != "." && current != string(filepath.Separator); current = filepath.Dir(current) {
+		if matchPattern(pattern, current) {
+			return true
 		}
-		if _, err := w.WriteString(line + "\n"); err != nil {
-			return err
+	}
+	return false
+}
+
+// defaultInclude selects every PHP file when the config has no include
+// patterns.
+var defaultInclude = []string{"**/*.php"}
+
+// shouldInclude reports whether a path matches one of the include patterns,
+// or of defaultInclude if there are none.
+func shouldInclude(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		patterns = defaultInclude
+	}
+	for _, pattern := range patterns {
+		if matchPattern(pattern, path) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func getGroupIndex(importPath string, groups []string) int {
-	if len(groups) == 0 {
-		return 0
-	}
-	for i, group := range groups {
-		if group == "*" {
-			// Check if it matches any OTHER group first?
-			// Usually * is the fallback.
-			// If we have ["*", "App"], "App\Foo" matches "App". "Vendor\Bar" matches "*".
-			// But if we iterate in order:
-			// 1. "*" -> Matches everything?
-			// If "*" is present, we should probably check specific matches first?
-			// Or does order matter? "vendor first" -> ["*", "App"]
-			// If I check "*" first, everything matches "*".
-			// So "*" should be treated as "matches if nothing else matches".
-			continue
+// processFile sorts the imports of a file in place and reports whether its
+// content changed, and how many import lines moved. Under --check the file
+// is only compared, never written.
+func processFile(filePath string, config *sorter.Config, opts *Options) (bool, int, error) {
+	if opts.readOnly() {
+		original, err := os.ReadFile(filePath)
+		if err != nil {
+			return false, 0, err
 		}
-		if strings.HasPrefix(importPath, group) {
-			return i
+		result, err := sorter.Sort(original, filePath, config, &opts.Options)
+		if err != nil {
+			return false, 0, err
+		}
+		if opts.Diff {
+			printDiff(filePath, original, result.Output)
 		}
+		return !bytes.Equal(original, result.Output), result.Moved, nil
 	}
 
-	// If we are here, it didn't match any specific group.
-	// Find index of "*"
-	for i, group := range groups {
-		if group == "*" {
-			return i
-		}
+	staged, err := sorter.Prepare(filePath, config, &opts.Options)
+	if err != nil {
+		return false, 0, err
+	}
+	defer staged.Discard()
+	return staged.Changed, staged.Moved, staged.Commit()
+}
+
+// parseRange parses a -range value, two 1-based line numbers such as 10:25.
+func parseRange(value string) (sorter.LineRange, error) {
+	startText, endText, ok := strings.Cut(value, ":")
+	start, startErr := strconv.Atoi(startText)
+	end, endErr := strconv.Atoi(endText)
+	if !ok || startErr != nil || endErr != nil || start < 1 || end < start {
+		return sorter.LineRange{}, fmt.Errorf("invalid -range %q, expected start:end with 1 <= start <= end", value)
+	}
+	return sorter.LineRange{Start: start, End: end}, nil
+}
+
+// sortStdin sorts PHP source read from stdin to stdout, without touching the
+// filesystem. name is only used in messages.
+func sortStdin(config *sorter.Config, name string, opts *Options) error {
+	original, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
 	}
+	result, err := sorter.Sort(original, name, config, &opts.Options)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(result.Output)
+	return err
+}
+
+// diffMu keeps the diffs of files processed in parallel from interleaving.
+var diffMu sync.Mutex
 
-	// If no "*" and no match, put at the end? or beginning?
-	// Let's put at the end (max int)
-	return len(groups)
+// printDiff prints the unified diff of a file's sorted content to stdout.
+func printDiff(filePath string, original, output []byte) {
+	diff := unifiedDiff(filepath.ToSlash(filePath), original, output)
+	if diff == "" {
+		return
+	}
+	diffMu.Lock()
+	defer diffMu.Unlock()
+	fmt.Print(diff)
 }
@@ -0,0 +1,1269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"psort/src/sorter"
+)
+
+const (
+	sortedSource   = "<?php\nuse A;\nuse B;\n"
+	unsortedSource = "<?php\nuse B;\nuse A;\n"
+	// brokenSource has a group use that is never closed
+	brokenSource = "<?php\nuse App\\{\n    A,\n"
+)
+
+// TestMain runs the command itself when the test binary is started by
+// runPsort, so that exit codes can be observed.
+func TestMain(m *testing.M) {
+	if os.Getenv("PSORT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runPsort runs the command with args in dir and returns its stdout, its
+// stderr and its exit code.
+func runPsort(t *testing.T, dir string, args ...string) (string, string, int) {
+	t.Helper()
+	return runPsortInput(t, dir, "", args...)
+}
+
+// runPsortInput is runPsort with stdin reading from input.
+func runPsortInput(t *testing.T, dir, input string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "PSORT_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running psort: %v", err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+// writeTree creates the files, keyed by slash-separated path, in a new
+// temporary directory and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// assertContent fails the test unless the file at name in dir holds want.
+func assertContent(t *testing.T, dir, name, want string) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func TestCheckExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		args   []string
+		want   int
+	}{
+		{"project sorted", sortedSource, []string{"-check"}, 0},
+		{"project unsorted", unsortedSource, []string{"-check"}, exitFailure},
+		{"file sorted", sortedSource, []string{"-check", "app/User.php"}, 0},
+		{"file unsorted", unsortedSource, []string{"-check", "app/User.php"}, exitFailure},
+		{"project broken", brokenSource, []string{"-check"}, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": tt.source})
+			stdout, stderr, code := runPsort(t, dir, tt.args...)
+			if code != tt.want {
+				t.Errorf("exit code = %d, want %d\nstdout: %s\nstderr: %s", code, tt.want, stdout, stderr)
+			}
+			if tt.want != 0 && tt.source == unsortedSource && !strings.Contains(stdout, "app/User.php") {
+				t.Errorf("stdout does not list app/User.php:\n%s", stdout)
+			}
+			assertContent(t, dir, "app/User.php", tt.source)
+		})
+	}
+}
+
+func TestListExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		listed string
+		want   int
+	}{
+		{"sorted", map[string]string{"app/User.php": sortedSource}, "", 0},
+		{"unsorted", map[string]string{"app/User.php": unsortedSource}, "app/User.php\n", 0},
+		{"broken", map[string]string{"app/User.php": unsortedSource, "app/Post.php": brokenSource}, "app/User.php\n", exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["psort.json"] = "{}"
+			dir := writeTree(t, tt.files)
+			stdout, stderr, code := runPsort(t, dir, "-l")
+			if code != tt.want {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.want, stderr)
+			}
+			if stdout != tt.listed {
+				t.Errorf("stdout = %q, want %q", stdout, tt.listed)
+			}
+			for name, content := range tt.files {
+				assertContent(t, dir, name, content)
+			}
+		})
+	}
+}
+
+func TestFailureExitCodes(t *testing.T) {
+	t.Run("unreadable file", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": unsortedSource})
+		// A dangling link can't be read, even by root
+		if err := os.Symlink("missing.php", filepath.Join(dir, "app", "Broken.php")); err != nil {
+			t.Fatal(err)
+		}
+		_, _, code := runPsort(t, dir, "-w")
+		if code != exitFailure {
+			t.Errorf("exit code = %d, want %d", code, exitFailure)
+		}
+		// The other files are still sorted
+		assertContent(t, dir, "app/User.php", sortedSource)
+	})
+	t.Run("single file", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"User.php": brokenSource})
+		if _, _, code := runPsort(t, dir, "User.php"); code != exitFailure {
+			t.Errorf("exit code = %d, want %d", code, exitFailure)
+		}
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"psort.json": `{"groups": 1}`, "app/User.php": unsortedSource})
+		if _, _, code := runPsort(t, dir, "-w"); code != exitConfigError {
+			t.Errorf("exit code = %d, want %d", code, exitConfigError)
+		}
+		assertContent(t, dir, "app/User.php", unsortedSource)
+	})
+	t.Run("missing config", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"app/User.php": unsortedSource})
+		if _, _, code := runPsort(t, dir, "-w"); code != exitConfigError {
+			t.Errorf("exit code = %d, want %d", code, exitConfigError)
+		}
+	})
+}
+
+func TestAtomicDirOneFileErrors(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":      "{}",
+		"app/User.php":    unsortedSource,
+		"app/Post.php":    unsortedSource,
+		"app/Broken.php":  brokenSource,
+		"lib/Helper.php":  unsortedSource,
+		"lib/Support.php": sortedSource,
+	})
+	stdout, stderr, code := runPsort(t, dir, "-w", "-atomic-dir")
+	if code != exitFailure {
+		t.Errorf("exit code = %d, want %d", code, exitFailure)
+	}
+	if !strings.Contains(stdout+stderr, "app/Broken.php") {
+		t.Errorf("output does not name the failing file:\n%s%s", stdout, stderr)
+	}
+	// Nothing in the directory with the failure changes
+	assertContent(t, dir, "app/User.php", unsortedSource)
+	assertContent(t, dir, "app/Post.php", unsortedSource)
+	assertContent(t, dir, "app/Broken.php", brokenSource)
+	// The other directory is written as usual
+	assertContent(t, dir, "lib/Helper.php", sortedSource)
+	assertContent(t, dir, "lib/Support.php", sortedSource)
+}
+
+func TestWarningsGoToStderr(t *testing.T) {
+	// Every import is longer than warn_on_long_imports allows
+	files := map[string]string{"psort.json": `{"warn_on_long_imports": 5}`, "app/User.php": unsortedSource}
+	tests := []struct {
+		name   string
+		args   []string
+		stdout string
+	}{
+		{"list", []string{"-l"}, "app/User.php\n"},
+		{"list and write", []string{"-l", "-w"}, "app/User.php\nScanned 1 files: 1 changed, 0 failed\n"},
+		{"project list only", nil, "app/User.php\n"},
+		{"check", []string{"-check"}, "Imports are not sorted in:\napp/User.php\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, files)
+			stdout, stderr, _ := runPsort(t, dir, tt.args...)
+			if stdout != tt.stdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.stdout)
+			}
+			if !strings.Contains(stderr, "Warning:") {
+				t.Errorf("stderr has no warning:\n%s", stderr)
+			}
+		})
+	}
+
+	t.Run("diff", func(t *testing.T) {
+		dir := writeTree(t, files)
+		stdout, stderr, _ := runPsort(t, dir, "-diff")
+		if strings.Contains(stdout, "Warning:") || !strings.HasPrefix(stdout, "--- ") {
+			t.Errorf("stdout is not only the diff:\n%s", stdout)
+		}
+		if !strings.Contains(stderr, "Warning:") {
+			t.Errorf("stderr has no warning:\n%s", stderr)
+		}
+	})
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		args  []string
+	}{
+		{"clean run", map[string]string{"psort.json": "{}", "app/User.php": sortedSource}, []string{"-q", "-w"}},
+		{"rewrite", map[string]string{"psort.json": "{}", "app/User.php": unsortedSource}, []string{"-q", "-w"}},
+		{"warnings", map[string]string{"psort.json": `{"warn_on_long_imports": 5}`, "app/User.php": unsortedSource}, []string{"-q", "-w"}},
+		{"no files matched", map[string]string{"psort.json": `{"include": ["missing/**/*.php"]}`}, []string{"-q", "-w"}},
+		{"no glob match", map[string]string{"app/User.php": sortedSource}, []string{"-q", "app/*.php", "lib/*.php"}},
+		{"single file", map[string]string{"User.php": unsortedSource}, []string{"-q", "User.php"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, tt.files)
+			stdout, stderr, code := runPsort(t, dir, tt.args...)
+			if stdout != "" || stderr != "" {
+				t.Errorf("printed under -q:\nstdout: %s\nstderr: %s", stdout, stderr)
+			}
+			if code != 0 {
+				t.Errorf("exit code = %d, want 0", code)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": brokenSource})
+		stdout, stderr, code := runPsort(t, dir, "-q", "-w")
+		if !strings.Contains(stdout+stderr, "app/User.php") {
+			t.Errorf("error not printed under -q:\nstdout: %s\nstderr: %s", stdout, stderr)
+		}
+		if code != exitFailure {
+			t.Errorf("exit code = %d, want %d", code, exitFailure)
+		}
+	})
+}
+
+func TestJSONReportMoved(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":   "{}",
+		"app/Post.php": "<?php\nuse C;\nuse A;\nuse B;\n",
+		"app/User.php": sortedSource,
+	})
+	stdout, stderr, code := runPsort(t, dir, "-format", "json", "-check")
+	if code != exitFailure {
+		t.Errorf("exit code = %d, want %d\nstderr: %s", code, exitFailure, stderr)
+	}
+	var report struct {
+		Files   []fileReport
+		Summary summaryReport
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, stdout)
+	}
+	want := []fileReport{
+		{Path: "app/Post.php", Changed: true, Moved: 3},
+		{Path: "app/User.php", Changed: false, Moved: 0},
+	}
+	if !slices.Equal(report.Files, want) {
+		t.Errorf("files = %+v, want %+v", report.Files, want)
+	}
+	if report.Summary != (summaryReport{Scanned: 2, Changed: 1, Moved: 3}) {
+		t.Errorf("summary = %+v", report.Summary)
+	}
+}
+
+func TestDebugMovedLines(t *testing.T) {
+	// -verbose is the former name of -debug
+	for _, flag := range []string{"-debug", "-verbose"} {
+		t.Run(flag, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": "{}", "app/Post.php": unsortedSource, "app/User.php": sortedSource})
+			_, stderr, code := runPsort(t, dir, flag, "-check")
+			if code != exitFailure {
+				t.Fatalf("exit code %d, want %d:\n%s", code, exitFailure, stderr)
+			}
+			if !strings.Contains(stderr, "app/Post.php: 2 import lines moved") {
+				t.Errorf("stderr does not report the moved lines:\n%s", stderr)
+			}
+			if strings.Contains(stderr, "app/User.php") {
+				t.Errorf("stderr reports a file with no moved lines:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestNormalizeCasingFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		classMap string
+	}{
+		{"list", `["App\\Http\\Controller", "App\\Models\\User", "App\\Models\\USER"]`},
+		{"object", `{"App\\Http\\Controller": "app/Http/Controller.php", "App\\Models\\User": "", "App\\Models\\USER": ""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{
+				"psort.json":    `{"normalize_casing_from": "classmap.json"}`,
+				"classmap.json": tt.classMap,
+				"app/Foo.php":   "<?php\nuse App\\Models\\user;\nuse App\\Http\\controller;\n",
+			})
+			stdout, stderr, code := runPsort(t, dir, "-w")
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			// Only the clear mismatch is corrected, the ambiguous one reported
+			assertContent(t, dir, "app/Foo.php", "<?php\nuse App\\Http\\Controller;\nuse App\\Models\\user;\n")
+			if !strings.Contains(stdout+stderr, `app/Foo.php: ambiguous casing for App\Models\user (App\Models\USER, App\Models\User)`) {
+				t.Errorf("the ambiguous import is not reported:\nstdout: %s\nstderr: %s", stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestDebugBlankLines(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":  `{"groups": ["*", "App\\"], "newline_between_groups": true, "blank_line_between_import_types": true, "alphabetical_buckets": true}`,
+		"app/Foo.php": "<?php\nuse function App\\f;\nuse App\\Foo;\nuse Zed;\nuse Bar;\nuse App\\Bar;\n",
+	})
+	stdout, stderr, code := runPsort(t, dir, "-debug", "-w")
+	if code != 0 {
+		t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"app/Foo.php: 1 blank line(s) before `use Zed;` (letter change B -> Z)\n",
+		"app/Foo.php: 1 blank line(s) before `use App\\Bar;` (group change 0 -> 1)\n",
+		"app/Foo.php: 1 blank line(s) before `use function App\\f;` (type change)\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr lacks %q:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stdout, "blank line") {
+		t.Errorf("stdout has debug output:\n%s", stdout)
+	}
+	// The annotations never end up in the file
+	assertContent(t, dir, "app/Foo.php", "<?php\nuse Bar;\n\nuse Zed;\n\nuse App\\Bar;\n\nuse App\\Foo;\n\nuse function App\\f;\n")
+}
+
+func TestFilesFrom0(t *testing.T) {
+	files := map[string]string{
+		"psort.json":         "{}",
+		"app/with space.php": unsortedSource,
+		"app/new\nline.php":  unsortedSource,
+		"app/Other.php":      unsortedSource,
+	}
+	const list = "app/with space.php\x00app/new\nline.php\x00"
+	for _, from := range []string{"file", "stdin"} {
+		t.Run(from, func(t *testing.T) {
+			dir := writeTree(t, files)
+			var stdout, stderr string
+			var code int
+			if from == "stdin" {
+				stdout, stderr, code = runPsortInput(t, dir, list, "-files-from0", "-")
+			} else {
+				if err := os.WriteFile(filepath.Join(dir, "list"), []byte(list), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				stdout, stderr, code = runPsort(t, dir, "-files-from0", "list")
+			}
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			assertContent(t, dir, "app/with space.php", sortedSource)
+			assertContent(t, dir, "app/new\nline.php", sortedSource)
+			assertContent(t, dir, "app/Other.php", unsortedSource)
+		})
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	const list = "# changed files\napp/A.php\n\nvendor/x/V.php\napp/B.php\n"
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		stdin  bool
+		sorted []string
+	}{
+		// vendor/x/V.php is listed, but excluded by the config
+		{"file", []string{"-w", "-from-file", "list.txt"}, false, []string{"app/A.php", "app/B.php"}},
+		{"stdin", []string{"-w", "-from-file", "-"}, true, []string{"app/A.php", "app/B.php"}},
+		{"no filter", []string{"-w", "-from-file", "list.txt", "-no-filter"}, false, []string{"app/A.php", "app/B.php", "vendor/x/V.php"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"psort.json": `{"exclude": ["vendor"]}`, "list.txt": list}
+			for _, name := range []string{"app/A.php", "app/B.php", "app/C.php", "vendor/x/V.php"} {
+				files[name] = unsortedSource
+			}
+			dir := writeTree(t, files)
+			input := ""
+			if tt.stdin {
+				input = list
+			}
+			stdout, stderr, code := runPsortInput(t, dir, input, tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			for _, name := range []string{"app/A.php", "app/B.php", "app/C.php", "vendor/x/V.php"} {
+				want := unsortedSource
+				if slices.Contains(tt.sorted, name) {
+					want = sortedSource
+				}
+				assertContent(t, dir, name, want)
+			}
+		})
+	}
+
+	dir := writeTree(t, map[string]string{"psort.json": "{}"})
+	if stdout, stderr, code := runPsort(t, dir, "-from-file", "missing.txt"); code != exitFailure || !strings.Contains(stdout+stderr, "Error reading file list") {
+		t.Errorf("exit code = %d, want %d with an error\nstdout: %s\nstderr: %s", code, exitFailure, stdout, stderr)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	// CRLF and a missing final newline show the backup is a byte for byte copy
+	const original = "<?php\r\nuse B;\r\nuse A;"
+	tests := []struct {
+		name   string
+		config string
+		args   []string
+		backup string
+	}{
+		{"flag", "{}", []string{"-w", "-backup"}, "app/User.php.bak"},
+		{"backup_suffix", `{"backup_suffix": ".orig"}`, []string{"-w"}, "app/User.php.orig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": tt.config, "app/User.php": original, "app/Post.php": sortedSource})
+			if stdout, stderr, code := runPsort(t, dir, tt.args...); code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			assertContent(t, dir, "app/User.php", "<?php\r\nuse A;\r\nuse B;")
+			assertContent(t, dir, tt.backup, original)
+			// Files left unchanged get no backup
+			matches, _ := filepath.Glob(filepath.Join(dir, "app", "Post.php.*"))
+			if len(matches) != 0 {
+				t.Errorf("unchanged file was backed up: %v", matches)
+			}
+		})
+	}
+
+	t.Run("check", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": original})
+		runPsort(t, dir, "-check", "-backup")
+		if _, err := os.Stat(filepath.Join(dir, "app", "User.php.bak")); !os.IsNotExist(err) {
+			t.Errorf("-check wrote a backup: %v", err)
+		}
+	})
+}
+
+func TestCollapseGroupUse(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":  `{"group_use": "collapse"}`,
+		"app/Foo.php": "<?php\nuse App\\Models\\User;\nuse App\\Models\\{Post, Comment};\nuse App\\Models\\Post;\nuse App\\Models\\Tag as Label;\nuse function App\\Models\\helper;\nuse App\\Http\\Request;\nuse Closure;\n",
+	})
+	if stdout, stderr, code := runPsort(t, dir, "-w"); code != 0 {
+		t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	// Single imports and group uses of a namespace are merged and deduplicated,
+	// other kinds and namespaces are kept apart
+	assertContent(t, dir, "app/Foo.php", "<?php\nuse App\\Http\\Request;\nuse App\\Models\\{Comment, Post, Tag as Label, User};\nuse Closure;\nuse function App\\Models\\helper;\n")
+}
+
+func TestExplain(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":   `{"groups": ["*", "App\\Http\\", "contains:\\Controller"], "separator_sorts_first": true}`,
+		"app/User.php": unsortedSource,
+	})
+	stdout, stderr, code := runPsort(t, dir, "-explain", `function App\Http\Controllers\UserController`)
+	if code != 0 {
+		t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+	}
+	want := "Import:     App\\Http\\Controllers\\UserController\n" +
+		"Kind:       function\n" +
+		"Matched by: prefix `App\\Http\\`, chosen among 2 matching groups\n" +
+		"Group:      1\n" +
+		"Sort key:   \"App\\x00Http\\x00Controllers\\x00UserController\"\n" +
+		"Groups:\n" +
+		"    0  *\n" +
+		"  > 1  App\\Http\\\n" +
+		"    2  contains:\\Controller\n"
+	if stdout != want {
+		t.Errorf("stdout:\n%s\nwant:\n%s", stdout, want)
+	}
+	// No file is processed
+	assertContent(t, dir, "app/User.php", unsortedSource)
+}
+
+func TestUnderscoreOrder(t *testing.T) {
+	const source = "<?php\nuse App\\GeneratedModel;\nuse App\\Generated_Model;\nuse App\\Generated2;\nuse App\\Generatedmodel;\n"
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"ascii", "<?php\nuse App\\Generated2;\nuse App\\GeneratedModel;\nuse App\\Generated_Model;\nuse App\\Generatedmodel;\n"},
+		{"first", "<?php\nuse App\\Generated_Model;\nuse App\\Generated2;\nuse App\\GeneratedModel;\nuse App\\Generatedmodel;\n"},
+		{"last", "<?php\nuse App\\Generated2;\nuse App\\GeneratedModel;\nuse App\\Generatedmodel;\nuse App\\Generated_Model;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": `{"underscore_order": "` + tt.order + `"}`, "app/Foo.php": source})
+			if stdout, stderr, code := runPsort(t, dir, "-w"); code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			assertContent(t, dir, "app/Foo.php", tt.want)
+		})
+	}
+}
+
+func TestConverge(t *testing.T) {
+	t.Run("stable", func(t *testing.T) {
+		dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": unsortedSource})
+		stdout, stderr, code := runPsort(t, dir, "-w", "-converge")
+		if code != 0 || strings.Contains(stdout+stderr, "converge") {
+			t.Errorf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		assertContent(t, dir, "app/User.php", sortedSource)
+	})
+	t.Run("diverging", func(t *testing.T) {
+		// A post_command that changes its own output every time never settles
+		dir := writeTree(t, map[string]string{"psort.json": `{"post_command": "sed 's/A;/AA;/'"}`, "app/User.php": unsortedSource})
+		stdout, stderr, code := runPsort(t, dir, "-w", "-converge")
+		if code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		for _, want := range []string{"app/User.php: sort did not converge after 3 passes", "line 2: `use AAAA;` -> `use AAAAA;`"} {
+			if !strings.Contains(stdout+stderr, want) {
+				t.Errorf("output lacks %q:\nstdout: %s\nstderr: %s", want, stdout, stderr)
+			}
+		}
+		assertContent(t, dir, "app/User.php", "<?php\nuse AAAAA;\nuse B;\n")
+	})
+}
+
+func TestAudit(t *testing.T) {
+	files := map[string]string{
+		"psort.json":    "{}",
+		"app/User.php":  unsortedSource,
+		"app/Post.php":  "<?php\nuse A;\nuse B;\nuse C;\n",
+		"app/Plain.php": "<?php\necho 1;\n",
+	}
+	for _, args := range [][]string{{"-audit"}, {"-audit", "-w"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			dir := writeTree(t, files)
+			stdout, stderr, code := runPsort(t, dir, args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+			}
+			// A file without imports counts as sorted
+			want := "Files scanned:      3\n" +
+				"Files with imports: 2\n" +
+				"Already sorted:     2\n" +
+				"Would change:       1\n" +
+				"Total imports:      5\n"
+			if stdout != want {
+				t.Errorf("stdout:\n%s\nwant:\n%s", stdout, want)
+			}
+			for name, content := range files {
+				assertContent(t, dir, name, content)
+			}
+		})
+	}
+}
+
+func TestFilterMode(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json": `{"groups": ["App\\", "*"], "newline_between_groups": true}`,
+	})
+	stdout, stderr, code := runPsortInput(t, dir, "<?php\nuse Zed;\nuse App\\Foo;\n\nclass X {}\n", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+	}
+	// The config's groups apply
+	if want := "<?php\nuse App\\Foo;\n\nuse Zed;\n\nclass X {}\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("filter mode wrote to the directory: %v", entries)
+	}
+}
+
+func TestConfigInParentDirectory(t *testing.T) {
+	const source = "<?php\nuse Zed;\nuse App\\Foo;\n"
+	const grouped = "<?php\nuse App\\Foo;\n\nuse Zed;\n"
+	files := map[string]string{
+		"repo/psort.json":           `{"groups": ["App\\", "*"], "newline_between_groups": true}`,
+		"repo/pkg/app/deep/Foo.php": source,
+		"repo/pkg/Top.php":          source,
+		"elsewhere/.keep":           "",
+	}
+	t.Run("project mode", func(t *testing.T) {
+		dir := writeTree(t, files)
+		// The walk starts from the current directory, not the config's
+		stdout, stderr, code := runPsort(t, filepath.Join(dir, "repo", "pkg", "app"), "-w")
+		if code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		assertContent(t, dir, "repo/pkg/app/deep/Foo.php", grouped)
+		assertContent(t, dir, "repo/pkg/Top.php", source)
+	})
+	t.Run("single file", func(t *testing.T) {
+		dir := writeTree(t, files)
+		// The search starts from the file's directory, not the current one
+		stdout, stderr, code := runPsort(t, filepath.Join(dir, "elsewhere"), "../repo/pkg/app/deep/Foo.php")
+		if code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		assertContent(t, dir, "repo/pkg/app/deep/Foo.php", grouped)
+	})
+}
+
+func TestProjectModeListsByDefault(t *testing.T) {
+	files := map[string]string{"psort.json": "{}", "app/User.php": unsortedSource, "app/Post.php": sortedSource}
+	dir := writeTree(t, files)
+	stdout, stderr, code := runPsort(t, dir)
+	if code != 0 {
+		t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+	}
+	if stdout != "app/User.php\n" {
+		t.Errorf("stdout = %q, want the unsorted file", stdout)
+	}
+	if !strings.Contains(stderr, "Run with -w to sort them") {
+		t.Errorf("stderr does not mention -w:\n%s", stderr)
+	}
+	for name, content := range files {
+		assertContent(t, dir, name, content)
+	}
+
+	t.Run("single file with -l", func(t *testing.T) {
+		dir := writeTree(t, files)
+		stdout, _, _ := runPsort(t, dir, "-l", "app/User.php")
+		if stdout != "app/User.php\n" {
+			t.Errorf("stdout = %q, want the unsorted file", stdout)
+		}
+		assertContent(t, dir, "app/User.php", unsortedSource)
+	})
+}
+
+func TestSummary(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":     "{}",
+		"app/User.php":   unsortedSource,
+		"app/sub/X.php":  unsortedSource,
+		"app/Post.php":   sortedSource,
+		"app/Broken.php": brokenSource,
+	})
+	stdout, _, code := runPsort(t, dir, "-w")
+	if code != exitFailure {
+		t.Errorf("exit code = %d, want %d", code, exitFailure)
+	}
+	want := "Scanned 4 files: 2 changed, 1 failed\n" +
+		"Failed:\n" +
+		"  app/Broken.php: line 2: use statement is never terminated by `;`\n"
+	if !strings.HasSuffix(stdout, want) {
+		t.Errorf("stdout does not end with the summary:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := writeTree(t, map[string]string{"psort.json": "{}", "app/S.php": sortedSource, "app/U.php": unsortedSource})
+	// run runs a cached, verbose sort and returns the files it processed
+	run := func(args ...string) []string {
+		t.Helper()
+		stdout, stderr, code := runPsort(t, dir, append([]string{"-cache", "-w", "-v"}, args...)...)
+		if code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		if !strings.Contains(stdout+stderr, "Scanned 2 files") {
+			t.Errorf("skipped files are not counted:\nstdout: %s\nstderr: %s", stdout, stderr)
+		}
+		var processed []string
+		for line := range strings.Lines(stdout + stderr) {
+			if name, ok := strings.CutPrefix(line, "Processing "); ok {
+				processed = append(processed, strings.TrimSuffix(name, "...\n"))
+			}
+		}
+		slices.Sort(processed)
+		return processed
+	}
+	both := []string{"app/S.php", "app/U.php"}
+
+	if got := run(); !slices.Equal(got, both) {
+		t.Fatalf("first run processed %q, want %q", got, both)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".psortcache")); err != nil {
+		t.Fatalf("no cache written: %v", err)
+	}
+	if got := run(); len(got) != 0 {
+		t.Errorf("unchanged files processed again: %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "app/S.php"), []byte(unsortedSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !slices.Equal(got, []string{"app/S.php"}) {
+		t.Errorf("after modifying app/S.php, processed %q", got)
+	}
+	assertContent(t, dir, "app/S.php", sortedSource)
+
+	if err := os.WriteFile(filepath.Join(dir, "psort.json"), []byte(`{"sort_by": "length"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !slices.Equal(got, both) {
+		t.Errorf("after changing the config, processed %q, want %q", got, both)
+	}
+
+	if got := run("-converge"); !slices.Equal(got, both) {
+		t.Errorf("turning -converge on processed %q, want %q", got, both)
+	}
+	if got := run("-converge"); len(got) != 0 {
+		t.Errorf("second -converge run processed %q", got)
+	}
+	if got := run(); !slices.Equal(got, both) {
+		t.Errorf("turning -converge off processed %q, want %q", got, both)
+	}
+}
+
+func TestSortedFileNotRewritten(t *testing.T) {
+	dir := writeTree(t, map[string]string{"psort.json": "{}", "app/User.php": sortedSource, "app/Post.php": unsortedSource})
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"User.php", "Post.php"} {
+		if err := os.Chtimes(filepath.Join(dir, "app", name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stdout, stderr, code := runPsort(t, dir, "-w"); code != 0 {
+		t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	info, err := os.Stat(filepath.Join(dir, "app", "User.php"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("sorted file's mtime changed from %v to %v", past, info.ModTime())
+	}
+	// The unsorted one is rewritten, so the check above means something
+	if info, err := os.Stat(filepath.Join(dir, "app", "Post.php")); err != nil || info.ModTime().Equal(past) {
+		t.Errorf("unsorted file was not rewritten: %v", err)
+	}
+}
+
+func TestExplicitConfig(t *testing.T) {
+	// The root psort.json would sort A first, the explicit one puts B first
+	files := map[string]string{
+		"psort.json":       "{}",
+		"build/psort.json": `{"groups": ["B", "*"], "include": ["app/*.php"]}`,
+		"app/User.php":     sortedSource,
+	}
+	const bFirst = "<?php\nuse B;\nuse A;\n"
+	for _, args := range [][]string{{"-w"}, {"app/User.php"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			dir := writeTree(t, files)
+			args := append([]string{"-config", "build/psort.json"}, args...)
+			if stdout, stderr, code := runPsort(t, dir, args...); code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			assertContent(t, dir, "app/User.php", bFirst)
+		})
+	}
+	for _, args := range [][]string{{"-w"}, {"app/User.php"}} {
+		t.Run("missing "+strings.Join(args, " "), func(t *testing.T) {
+			dir := writeTree(t, files)
+			args := append([]string{"-config", "missing.json"}, args...)
+			stdout, stderr, code := runPsort(t, dir, args...)
+			if code != exitConfigError {
+				t.Errorf("exit code = %d, want %d", code, exitConfigError)
+			}
+			if !strings.Contains(stdout+stderr, "Error loading config: open missing.json") {
+				t.Errorf("the missing config is not reported:\nstdout: %s\nstderr: %s", stdout, stderr)
+			}
+			assertContent(t, dir, "app/User.php", sortedSource)
+		})
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	files := map[string]string{"psort.json": "{}"}
+	for _, name := range []string{"app/A.php", "app/B.php", "app/sub/C.php", "lib/D.php"} {
+		files[name] = unsortedSource
+	}
+	for _, tt := range []struct {
+		name   string
+		config string
+		args   []string
+	}{
+		{"flag", "{}", []string{"-j", "1", "-w"}},
+		{"config", `{"concurrency": 1}`, []string{"-w"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := maps.Clone(files)
+			files["psort.json"] = tt.config
+			dir := writeTree(t, files)
+			stdout, stderr, code := runPsort(t, dir, tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			if !strings.Contains(stdout, "Scanned 4 files: 4 changed, 0 failed") {
+				t.Errorf("stdout lacks the summary:\n%s", stdout)
+			}
+			for name := range files {
+				if strings.HasSuffix(name, ".php") {
+					assertContent(t, dir, name, sortedSource)
+				}
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		config string
+		args   []string
+		want   string
+	}{
+		{"-j 0", "{}", []string{"-j", "0", "-w"}, "-j must be at least 1"},
+		{"negative -j", "{}", []string{"-j", "-2", "-w"}, "-j must be at least 1"},
+		{"concurrency 0", `{"concurrency": 0}`, []string{"-w"}, "concurrency: must be at least 1, got 0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": tt.config, "app/A.php": unsortedSource})
+			stdout, stderr, code := runPsort(t, dir, tt.args...)
+			if code != exitConfigError || !strings.Contains(stdout+stderr, tt.want) {
+				t.Errorf("exit code = %d, want %d with %q\nstdout: %s\nstderr: %s", code, exitConfigError, tt.want, stdout, stderr)
+			}
+			assertContent(t, dir, "app/A.php", unsortedSource)
+		})
+	}
+}
+
+func TestSerial(t *testing.T) {
+	files := map[string]string{"psort.json": "{}", "a/broken.php": brokenSource}
+	names := []string{"a/x.php", "b/y.php", "c.php", "z.php"}
+	for _, name := range names {
+		files[name] = unsortedSource
+	}
+	dir := writeTree(t, files)
+	stdout, stderr, code := runPsort(t, dir, "-serial", "-v")
+	if code != exitFailure {
+		t.Fatalf("exit code = %d, want %d\nstdout: %s\nstderr: %s", code, exitFailure, stdout, stderr)
+	}
+	againOut, againErr, _ := runPsort(t, dir, "-serial", "-v")
+	if againOut != stdout {
+		t.Errorf("second run's stdout differs:\n%s\nfirst:\n%s", againOut, stdout)
+	}
+	if againErr != stderr {
+		t.Errorf("second run's stderr differs:\n%s\nfirst:\n%s", againErr, stderr)
+	}
+	var processed []string
+	for line := range strings.Lines(stderr) {
+		if name, ok := strings.CutPrefix(line, "Processing "); ok {
+			processed = append(processed, strings.TrimSuffix(name, "...\n"))
+		}
+	}
+	if want := append([]string{"a/broken.php"}, names...); !slices.Equal(processed, want) {
+		t.Errorf("processed %q, want %q", processed, want)
+	}
+}
+
+func TestDefaultInclude(t *testing.T) {
+	for _, tt := range []struct {
+		name, config string
+		sorted       []string
+	}{
+		{"no include", `{"groups": ["App\\", "*"]}`, []string{"Root.php", "app/A.php", "app/deep/B.php"}},
+		{"empty include", `{"include": []}`, []string{"Root.php", "app/A.php", "app/deep/B.php"}},
+		{"explicit include", `{"include": ["app/*.php"]}`, []string{"app/A.php"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"psort.json": tt.config}
+			for _, name := range []string{"Root.php", "app/A.php", "app/deep/B.php", "app/notes.txt", "app/legacy.inc"} {
+				files[name] = unsortedSource
+			}
+			dir := writeTree(t, files)
+			stdout, stderr, code := runPsort(t, dir, "-w")
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+			}
+			for name := range files {
+				if name == "psort.json" {
+					continue
+				}
+				want := unsortedSource
+				if slices.Contains(tt.sorted, name) {
+					want = sortedSource
+				}
+				assertContent(t, dir, name, want)
+			}
+		})
+	}
+}
+
+func TestNoFilesMatched(t *testing.T) {
+	const warning = "Warning: no files matched the include patterns srcx/**/*.php"
+	for _, tt := range []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"warns", []string{"-w"}, 0},
+		{"strict", []string{"-w", "-strict"}, exitFailure},
+		{"strict check", []string{"-check", "-strict"}, exitFailure},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": `{"include": ["srcx/**/*.php"]}`, "src/A.php": unsortedSource})
+			stdout, stderr, code := runPsort(t, dir, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstdout: %s\nstderr: %s", code, tt.code, stdout, stderr)
+			}
+			if !strings.Contains(stdout+stderr, warning) {
+				t.Errorf("output lacks %q\nstdout: %s\nstderr: %s", warning, stdout, stderr)
+			}
+			assertContent(t, dir, "src/A.php", unsortedSource)
+		})
+	}
+
+	// A match is enough, sorted or not
+	dir := writeTree(t, map[string]string{"psort.json": `{"include": ["src/**/*.php"]}`, "src/A.php": sortedSource})
+	if stdout, stderr, code := runPsort(t, dir, "-w", "-strict"); code != 0 || strings.Contains(stdout+stderr, "no files matched") {
+		t.Errorf("exit code = %d, want 0\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	files := map[string]string{
+		// gen/Model.php is included by name, but exclude wins
+		"psort.json": `{"include": ["gen/Model.php", "**/*.php"], "exclude": ["src", "app", "gen/*.php"]}`,
+	}
+	for _, name := range []string{"src/A.php", "src-legacy/B.php", "app/C.php", "app.php", "application/D.php", "gen/Model.php"} {
+		files[name] = unsortedSource
+	}
+	dir := writeTree(t, files)
+	stdout, stderr, code := runPsort(t, dir, "-w")
+	if code != 0 {
+		t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	for _, name := range []string{"src-legacy/B.php", "app.php", "application/D.php"} {
+		assertContent(t, dir, name, sortedSource)
+	}
+	for _, name := range []string{"src/A.php", "app/C.php", "gen/Model.php"} {
+		assertContent(t, dir, name, unsortedSource)
+	}
+}
+
+func TestPsortIgnore(t *testing.T) {
+	files := map[string]string{
+		"psort.json":           "{}",
+		".psortignore":         "# generated and legacy code\nlegacy/\n!legacy/keep.php\n\n**/*.gen.php\n",
+		"app/User.php":         unsortedSource,
+		"app/User.gen.php":     unsortedSource,
+		"legacy/Old.php":       unsortedSource,
+		"legacy/sub/Older.php": unsortedSource,
+		"legacy/keep.php":      unsortedSource,
+	}
+	dir := writeTree(t, files)
+	if stdout, stderr, code := runPsort(t, dir, "-w"); code != 0 {
+		t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	assertContent(t, dir, "app/User.php", sortedSource)
+	assertContent(t, dir, "legacy/keep.php", sortedSource)
+	for _, name := range []string{"app/User.gen.php", "legacy/Old.php", "legacy/sub/Older.php"} {
+		assertContent(t, dir, name, unsortedSource)
+	}
+}
+
+func TestPathArguments(t *testing.T) {
+	files := map[string]string{
+		"psort.json":       "{}",
+		"app/User.php":     unsortedSource,
+		"app/sub/Post.php": unsortedSource,
+		"app/Other.php":    unsortedSource,
+		"lib/Lib.php":      unsortedSource,
+	}
+	t.Run("explicit files", func(t *testing.T) {
+		dir := writeTree(t, files)
+		if stdout, stderr, code := runPsort(t, dir, "app/User.php", "app/sub/Post.php"); code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		assertContent(t, dir, "app/User.php", sortedSource)
+		assertContent(t, dir, "app/sub/Post.php", sortedSource)
+		// No directory walk
+		assertContent(t, dir, "app/Other.php", unsortedSource)
+		assertContent(t, dir, "lib/Lib.php", unsortedSource)
+	})
+	t.Run("glob", func(t *testing.T) {
+		dir := writeTree(t, files)
+		// Quoted, so psort expands the ** itself
+		if stdout, stderr, code := runPsort(t, dir, "app/**/*.php"); code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		for _, name := range []string{"app/User.php", "app/sub/Post.php", "app/Other.php"} {
+			assertContent(t, dir, name, sortedSource)
+		}
+		assertContent(t, dir, "lib/Lib.php", unsortedSource)
+	})
+}
+
+func TestRespectGitignore(t *testing.T) {
+	files := map[string]string{
+		".gitignore":        "vendor/\n*.gen.php\n",
+		"app/.gitignore":    "cache\n",
+		"vendor/lib/V.php":  unsortedSource,
+		"app/cache/C.php":   unsortedSource,
+		"app/src/S.php":     unsortedSource,
+		"app/src/S.gen.php": unsortedSource,
+	}
+	tests := []struct {
+		name   string
+		config string
+		listed string
+	}{
+		{"default", "{}", "app/src/S.php\n"},
+		{"disabled", `{"respect_gitignore": false}`, "app/cache/C.php\napp/src/S.gen.php\napp/src/S.php\nvendor/lib/V.php\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := maps.Clone(files)
+			files["psort.json"] = tt.config
+			dir := writeTree(t, files)
+			stdout, stderr, code := runPsort(t, dir, "-l", "-serial")
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+			}
+			if stdout != tt.listed {
+				t.Errorf("stdout = %q, want %q", stdout, tt.listed)
+			}
+		})
+	}
+}
+
+func TestJSONReportShape(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"psort.json":     "{}",
+		"app/Broken.php": brokenSource,
+		"app/Post.php":   sortedSource,
+		"app/User.php":   unsortedSource,
+	})
+	stdout, stderr, code := runPsort(t, dir, "-format", "json", "-w")
+	if code != exitFailure {
+		t.Errorf("exit code = %d, want %d\nstderr: %s", code, exitFailure, stderr)
+	}
+	// The whole of stdout is the report, without progress lines, and only a
+	// failed record has an error
+	want := `{
+  "files": [
+    {
+      "path": "app/Broken.php",
+      "changed": false,
+      "moved": 0,
+      "error": "line 2: use statement is never terminated by ` + "`;`" + `"
+    },
+    {
+      "path": "app/Post.php",
+      "changed": false,
+      "moved": 0
+    },
+    {
+      "path": "app/User.php",
+      "changed": true,
+      "moved": 2
+    }
+  ],
+  "summary": {
+    "scanned": 3,
+    "changed": 1,
+    "failed": 1,
+    "moved": 2
+  }
+}
+`
+	if stdout != want {
+		t.Errorf("stdout:\n%s\nwant:\n%s", stdout, want)
+	}
+	assertContent(t, dir, "app/User.php", sortedSource)
+}
+
+func TestStdinFilepath(t *testing.T) {
+	const input = "<?php\nuse Zed;\nuse App\\Foo;\n"
+	dir := writeTree(t, map[string]string{
+		"project/psort.json": `{"groups": ["App\\", "*"], "newline_between_groups": true, "exclude": ["legacy"]}`,
+		"elsewhere/.keep":    "",
+	})
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		// The file needn't exist, only its directory is searched for a config
+		{"config found", "../project/app/New.php", "<?php\nuse App\\Foo;\n\nuse Zed;\n"},
+		{"excluded", "../project/legacy/Old.php", input},
+		{"no config", "Foo.php", "<?php\nuse App\\Foo;\nuse Zed;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runPsortInput(t, filepath.Join(dir, "elsewhere"), input, "-stdin-filepath", tt.path, "-")
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value string
+		want  sorter.LineRange
+		ok    bool
+	}{
+		{"10:25", sorter.LineRange{Start: 10, End: 25}, true},
+		{"3:3", sorter.LineRange{Start: 3, End: 3}, true},
+		{"25:10", sorter.LineRange{}, false},
+		{"a:b", sorter.LineRange{}, false},
+		{"1:x", sorter.LineRange{}, false},
+		{"10:", sorter.LineRange{}, false},
+		{":25", sorter.LineRange{}, false},
+		{"10", sorter.LineRange{}, false},
+		{"0:5", sorter.LineRange{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRange(tt.value)
+			if tt.ok {
+				if err != nil || got != tt.want {
+					t.Errorf("parseRange = %+v, %v, want %+v", got, err, tt.want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "expected start:end with 1 <= start <= end") {
+				t.Errorf("parseRange: err = %v, want an invalid -range error", err)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	// Two use blocks, on lines 2-3 and 6-7
+	const input = "<?php\nuse B;\nuse A;\n\necho 1;\nuse D;\nuse C;\n"
+	dir := writeTree(t, map[string]string{"psort.json": "{}"})
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"3:3", "<?php\nuse A;\nuse B;\n\necho 1;\nuse D;\nuse C;\n"},
+		{"5:6", "<?php\nuse B;\nuse A;\n\necho 1;\nuse C;\nuse D;\n"},
+		{"4:5", input},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			stdout, stderr, code := runPsortInput(t, dir, input, "-range", tt.value, "-")
+			if code != 0 {
+				t.Fatalf("exit code = %d\nstderr: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		if _, _, code := runPsortInput(t, dir, input, "-range", "7:2", "-"); code != exitConfigError {
+			t.Errorf("exit code = %d, want %d", code, exitConfigError)
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("idempotent", func(t *testing.T) {
+		// Groups, comments, blank lines and a wrapped group use in one block
+		const source = "<?php\nnamespace App\\Http;\n\nuse Zed;\n// the kernel\nuse App\\Kernel;\n\n\nuse function App\\helper;\nuse App\\Models\\{\n    User,\n    Post,\n};\nuse Alpha; # trailing\n\nclass X {}\n"
+		dir := writeTree(t, map[string]string{
+			"psort.json":  `{"groups": ["App\\", "*"], "newline_between_groups": true, "blank_line_between_import_types": true}`,
+			"app/Foo.php": source,
+		})
+		if stdout, stderr, code := runPsort(t, dir, "-w", "-verify"); code != 0 {
+			t.Fatalf("exit code = %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+		}
+		assertContent(t, dir, "app/Foo.php", "<?php\nnamespace App\\Http;\n\n// the kernel\nuse App\\Kernel;\nuse App\\Models\\{\n    Post,\n    User,\n};\n\nuse Alpha; # trailing\nuse Zed;\n\nuse function App\\helper;\n\nclass X {}\n")
+	})
+	for _, args := range [][]string{{"-w", "-verify"}, {"-check", "-verify"}} {
+		t.Run("diverging "+args[0], func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"psort.json": `{"post_command": "sed 's/A;/AA;/'"}`, "app/User.php": unsortedSource})
+			stdout, stderr, code := runPsort(t, dir, args...)
+			if code != exitFailure {
+				t.Errorf("exit code = %d, want %d", code, exitFailure)
+			}
+			for _, want := range []string{"app/User.php: second pass differs from the sorted output", "line 2: `use AA;` -> `use AAA;`", "write skipped"} {
+				if !strings.Contains(stdout+stderr, want) {
+					t.Errorf("output lacks %q:\nstdout: %s\nstderr: %s", want, stdout, stderr)
+				}
+			}
+			assertContent(t, dir, "app/User.php", unsortedSource)
+		})
+	}
+}
+
+func TestSingleFileMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		args   []string
+		stdout string
+		code   int
+	}{
+		{"changed", unsortedSource, nil, "Successfully sorted imports in User.php\n", 0},
+		{"unchanged", sortedSource, nil, "Imports in User.php are already sorted\n", 0},
+		{"quiet", unsortedSource, []string{"-q"}, "", 0},
+		{"check unsorted", unsortedSource, []string{"-check"}, "Imports are not sorted in:\nUser.php\n", exitFailure},
+		{"check sorted", sortedSource, []string{"-check"}, "Imports in User.php are sorted\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"User.php": tt.source})
+			stdout, stderr, code := runPsort(t, dir, append(tt.args, "User.php")...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.stdout)
+			}
+		})
+	}
+}
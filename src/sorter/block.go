@@ -0,0 +1,1149 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// writeSortedBlock sorts and writes a use block, returning how many of its
+// lines ended up at a different index.
+func writeSortedBlock(w *bytes.Buffer, block []string, config *Config, opts *Options, filePath, namespace string) (int, error) {
+	if config.NormalizeWhitespace {
+		normalizeWhitespace(block)
+	}
+	if config.StripLeadingBackslash {
+		stripLeadingBackslash(block)
+	}
+	if config.classMap != nil {
+		normalizeCasing(block, config.classMap, opts, filePath)
+	}
+	if config.ReportSameNamespace {
+		reportSameNamespace(block, opts, filePath, namespace)
+	}
+	switch config.GroupUse {
+	case "collapse":
+		block = collapseGroupUse(block, config.MaxLineWidth)
+	case "expand":
+		block = expandGroupUse(block)
+	}
+	sortGroupMembers(block, config)
+	if config.RemoveDuplicates == nil || *config.RemoveDuplicates {
+		block = removeDuplicates(block, opts, filePath)
+	}
+
+	byType := config.ImportTypes != "interleave"
+	unsorted := append([]string(nil), block...)
+	// The comparison ends with the full lines, so only identical lines tie,
+	// and those keep their order
+	sort.SliceStable(block, func(i, j int) bool {
+		// Comments don't take part in the ordering
+		lineI := importStatement(block[i])
+		lineJ := importStatement(block[j])
+
+		kindI, importI := parseImport(lineI)
+		kindJ, importJ := parseImport(lineJ)
+
+		// Pinned imports come first, in the order they are listed
+		if rankI, rankJ := pinnedRank(importI, config.Pinned), pinnedRank(importJ, config.Pinned); rankI != rankJ {
+			return rankI < rankJ
+		}
+		// Class, function and const imports form separate sections
+		if byType && kindI != kindJ {
+			return kindI < kindJ
+		}
+
+		groupI := getGroupIndex(kindI, importI, config, namespace)
+		groupJ := getGroupIndex(kindJ, importJ, config, namespace)
+
+		if groupI != groupJ {
+			return groupI < groupJ
+		}
+		// Overrides hold the start and end of the group, whatever its order
+		if rankI, rankJ := config.OrderOverrides.rank(importI), config.OrderOverrides.rank(importJ); rankI != rankJ {
+			return rankI < rankJ
+		}
+		rawI, rawJ := block[i], block[j]
+		sortBy, descending := config.groupOrder(groupI)
+		if descending {
+			lineI, lineJ, kindI, kindJ, importI, importJ, rawI, rawJ = lineJ, lineI, kindJ, kindI, importJ, importI, rawJ, rawI
+		}
+		if rankI, rankJ := sortRank(importI, sortBy), sortRank(importJ, sortBy); rankI != rankJ {
+			return rankI < rankJ
+		}
+		// Interleaved kinds are ordered by name, ignoring the qualifier
+		if config.transformsSortKey() || kindI != kindJ {
+			keyI, keyJ := sortKey(importI, config), sortKey(importJ, config)
+			if keyI != keyJ {
+				return keyI < keyJ
+			}
+		}
+		if lineI != lineJ {
+			return lineI < lineJ
+		}
+		return rawI < rawJ
+	})
+	if len(config.FreezeImports) > 0 {
+		block = restoreFrozen(unsorted, block, config.FreezeImports)
+	}
+
+	// Split into the class, function and const sections, keeping only the
+	// non-empty ones, so that separators only ever appear between two sections.
+	// Without blank_line_between_import_types, sections are only separated
+	// when the group changes across the boundary.
+	// Pinned imports form a section of their own above the others, whatever
+	// their kind.
+	sections := [][]string{block}
+	if byType {
+		pinned := 0
+		for pinned < len(block) && isPinned(block[pinned], config.Pinned) {
+			pinned++
+		}
+		sections = splitByKind(block[pinned:])
+		if pinned > 0 {
+			sections = append([][]string{block[:pinned]}, sections...)
+		}
+	}
+
+	// A group's header is written once per block, above the first section
+	// with imports of that group
+	headers := make(map[int]bool)
+	for s, section := range sections {
+		if s > 0 {
+			previous := sections[s-1][len(sections[s-1])-1]
+			previousKind, previousImport := parseImport(previous)
+			currentKind, currentImport := parseImport(section[0])
+			var reasons []string
+			blank := 0
+			if config.BlankLineBetweenImportTypes && previousKind != currentKind {
+				reasons = append(reasons, "type change")
+				blank = 1
+			}
+			if spacing := config.groupSpacing(); spacing > 0 && len(config.Groups) > 0 {
+				from := getGroupIndex(previousKind, previousImport, config, namespace)
+				to := getGroupIndex(currentKind, currentImport, config, namespace)
+				if from != to {
+					reasons = append(reasons, fmt.Sprintf("group change %d -> %d", from, to))
+					blank = max(blank, spacing)
+				}
+			}
+			if blank > 0 {
+				opts.debugf("%s: %d blank line(s) before `%s` (%s)", filePath, blank, strings.TrimSpace(section[0]), strings.Join(reasons, ", "))
+				if _, err := w.WriteString(strings.Repeat("\n", blank)); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err := writeSection(w, section, headers, config, opts, filePath, namespace); err != nil {
+			return 0, err
+		}
+	}
+
+	moved := 0
+	for i, line := range block {
+		if i >= len(unsorted) || unsorted[i] != line {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// removeDuplicates drops repeated imports, keeping the first occurrence.
+// Imports are compared by their kind, path and alias, ignoring whitespace
+// and comments, so `use A\B as C;` and `use A\B;` are both kept.
+func removeDuplicates(block []string, opts *Options, filePath string) []string {
+	seen := make(map[string]bool, len(block))
+	result := make([]string, 0, len(block))
+	for _, line := range block {
+		key := duplicateKey(line)
+		if seen[key] {
+			opts.debugf("%s: removed duplicate `%s`", filePath, strings.TrimSpace(line))
+			continue
+		}
+		seen[key] = true
+		result = append(result, line)
+	}
+	return result
+}
+
+// duplicateKey returns the kind, path and alias of an import, written the
+// same way however the statement is spaced: `use  Zed ;` and `use Zed;`
+// have the same key, as do `use A\B AS C;` and `use A\B as C;`.
+func duplicateKey(line string) string {
+	statement := strings.TrimSuffix(importStatement(line), ";")
+	kind, importPath := parseImport(strings.Join(strings.Fields(statement), " "))
+	fields := strings.Fields(importPath)
+	alias := ""
+	if len(fields) == 3 && strings.EqualFold(fields[1], "as") {
+		fields, alias = fields[:1], fields[2]
+	}
+	return fmt.Sprintf("%d %s as %s", kind, strings.Join(fields, " "), alias)
+}
+
+// restoreFrozen moves frozen imports back to their original index in the
+// block. The remaining imports keep their sorted order and fill the other
+// positions, so with several frozen imports each one stays exactly where it
+// was and everything else is sorted around them.
+func restoreFrozen(unsorted, sorted []string, frozen []string) []string {
+	isFrozen := func(line string) bool {
+		_, importPath := parseImport(line)
+		return matchesFrozen(importPath, frozen)
+	}
+
+	var rest []string
+	for _, line := range sorted {
+		if !isFrozen(line) {
+			rest = append(rest, line)
+		}
+	}
+
+	result := make([]string, 0, len(unsorted))
+	for _, line := range unsorted {
+		if isFrozen(line) {
+			result = append(result, line)
+			continue
+		}
+		result = append(result, rest[0])
+		rest = rest[1:]
+	}
+	return result
+}
+
+// matchesFrozen reports whether an import is listed in freeze_imports, either
+// exactly or below an entry ending with a namespace separator.
+func matchesFrozen(importPath string, frozen []string) bool {
+	name := strings.TrimPrefix(importName(importPath), "\\")
+	if name == "" {
+		return false
+	}
+	for _, entry := range frozen {
+		entry = strings.TrimPrefix(entry, "\\")
+		if name == entry || (strings.HasSuffix(entry, "\\") && strings.HasPrefix(name, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedRank returns the position of an import in pinned, compared like
+// freeze_imports entries, or len(pinned) if it is not pinned.
+func pinnedRank(importPath string, pinned []string) int {
+	for i, entry := range pinned {
+		if matchesFrozen(importPath, []string{entry}) {
+			return i
+		}
+	}
+	return len(pinned)
+}
+
+// rank orders an import within its group: negative for the First entries,
+// in their order, 0 for the imports not listed, and positive for the Last
+// entries. Names are compared exactly, ignoring a leading `\` and an alias.
+func (o OrderOverrides) rank(importPath string) int {
+	name := strings.TrimPrefix(importName(importPath), "\\")
+	if name == "" {
+		return 0
+	}
+	for i, entry := range o.First {
+		if name == strings.TrimPrefix(entry, "\\") {
+			return i - len(o.First)
+		}
+	}
+	for i, entry := range o.Last {
+		if name == strings.TrimPrefix(entry, "\\") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// isPinned reports whether a use block line imports one of the pinned names.
+func isPinned(line string, pinned []string) bool {
+	_, importPath := parseImport(line)
+	return pinnedRank(importPath, pinned) < len(pinned)
+}
+
+// splitByKind splits a sorted block into its non-empty runs of class,
+// function and const imports.
+func splitByKind(block []string) [][]string {
+	var sections [][]string
+	for i, line := range block {
+		kind, _ := parseImport(line)
+		if i == 0 {
+			sections = append(sections, nil)
+		} else if previous, _ := parseImport(block[i-1]); previous != kind {
+			sections = append(sections, nil)
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], line)
+	}
+	return sections
+}
+
+// writeSection writes one sorted section of a use block, with the headers of
+// the groups not in headers yet and the blank lines between groups and
+// alphabetical buckets.
+func writeSection(w *bytes.Buffer, section []string, headers map[int]bool, config *Config, opts *Options, filePath, namespace string) error {
+	groups := config.Groups
+	lastGroup := -1
+	for i, line := range section {
+		currentKind, currentImport := parseImport(line)
+		currentGroup := getGroupIndex(currentKind, currentImport, config, namespace)
+		if i > 0 {
+			var reasons []string
+			blank := 0
+			if spacing := config.groupSpacing(); len(groups) > 0 && currentGroup != lastGroup && spacing > 0 {
+				reasons = append(reasons, fmt.Sprintf("group change %d -> %d", lastGroup, currentGroup))
+				blank = spacing
+			}
+			if config.AlphabeticalBuckets && currentGroup == lastGroup {
+				_, previousImport := parseImport(section[i-1])
+				previous := bucketLetter(previousImport, currentGroup, groups, namespace)
+				current := bucketLetter(currentImport, currentGroup, groups, namespace)
+				if previous != current {
+					reasons = append(reasons, fmt.Sprintf("letter change %c -> %c", previous, current))
+					blank = max(blank, 1)
+				}
+			}
+			if blank > 0 {
+				opts.debugf("%s: %d blank line(s) before `%s` (%s)", filePath, blank, strings.TrimSpace(line), strings.Join(reasons, ", "))
+				if _, err := w.WriteString(strings.Repeat("\n", blank)); err != nil {
+					return err
+				}
+			}
+		}
+		if currentGroup != lastGroup {
+			if !headers[currentGroup] {
+				if err := writeGroupHeader(w, currentGroup, groups); err != nil {
+					return err
+				}
+				headers[currentGroup] = true
+			}
+			lastGroup = currentGroup
+		}
+		if err := writeLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeCasing rewrites class imports whose casing differs from the single
+// canonical spelling in the class map. Names with several spellings differing
+// only in case are reported and left untouched.
+func normalizeCasing(block []string, classMap map[string][]string, opts *Options, filePath string) {
+	for i, line := range block {
+		kind, importPath := parseImport(line)
+		if kind != kindClass || strings.Contains(importPath, "{") {
+			continue
+		}
+		fields := strings.Fields(importPath)
+		if len(fields) == 0 {
+			continue
+		}
+		className := strings.TrimPrefix(fields[0], "\\")
+		candidates := classMap[strings.ToLower(className)]
+		if len(candidates) == 0 || containsString(candidates, className) {
+			continue
+		}
+		if len(candidates) > 1 {
+			opts.warnf("%s: ambiguous casing for %s (%s)", filePath, className, strings.Join(candidates, ", "))
+			continue
+		}
+		comments, statement := splitAttachedComments(line)
+		indent, content := splitIndent(statement)
+		block[i] = comments + indent + strings.Replace(content, className, candidates[0], 1)
+	}
+}
+
+// groupUseSpacing matches the spacing normalizeWhitespace rewrites around
+// the braces and commas of a group use.
+var groupUseSpacing = regexp.MustCompile(`\{ | \}| ?, ?`)
+
+// normalizeWhitespace rewrites single-line use statements to their canonical
+// spacing: one space between words, a lowercase `as`, nothing before the
+// `;`, and in a group use none inside the braces and one after each comma,
+// as in `use App\{Foo, Bar as Baz};`. Names keep their casing, and trailing
+// comments and statements spanning several lines are left as they are.
+func normalizeWhitespace(block []string) {
+	for i, line := range block {
+		comments, statement := splitAttachedComments(line)
+		indent, content := splitIndent(statement)
+		end := strings.Index(content, ";")
+		if end < 0 || strings.Contains(content[:end], "\n") {
+			continue
+		}
+		if _, importPath := parseImport(content); importName(importPath) == "" {
+			// A half-typed statement such as `use ;`
+			continue
+		}
+		words := strings.Fields(content[:end])
+		for j, word := range words {
+			if j > 0 && strings.EqualFold(word, "as") {
+				words[j] = "as"
+			}
+		}
+		normalized := strings.Join(words, " ")
+		normalized = groupUseSpacing.ReplaceAllStringFunc(normalized, func(spacing string) string {
+			return strings.TrimSpace(spacing) + strings.Repeat(" ", strings.Count(spacing, ","))
+		})
+		block[i] = comments + indent + normalized + content[end:]
+	}
+}
+
+// leadingBackslash matches the start of a use statement up to the `\` of a
+// fully qualified name, after any function or const qualifier.
+var leadingBackslash = regexp.MustCompile(`^(use\s+(?:(?:function|const)\s+)?)\\`)
+
+// stripLeadingBackslash rewrites fully qualified imports such as
+// `use \App\Foo;` or `use function \App\helper;` without the leading `\`,
+// which PHP ignores in use statements.
+func stripLeadingBackslash(block []string) {
+	for i, line := range block {
+		comments, statement := splitAttachedComments(line)
+		indent, content := splitIndent(statement)
+		block[i] = comments + indent + leadingBackslash.ReplaceAllString(content, "$1")
+	}
+}
+
+// collapseGroupUse merges imports of the same kind sharing a parent namespace,
+// whether single imports or existing group uses, into one sorted and
+// deduplicated group use. The merged declaration takes the place of the first
+// import it replaces. Group uses with per-member qualifiers are left alone.
+// A group use longer than maxWidth characters, unless that is 0, is written
+// with one member per line, and one that fits on a single line. With a
+// maxWidth, this also applies to a group use that nothing is merged into.
+func collapseGroupUse(block []string, maxWidth int) []string {
+	type merged struct {
+		first   int
+		members []string
+		sources int
+		// grouped is set when one of the sources is already a group use
+		grouped bool
+	}
+	byNamespace := make(map[string]*merged)
+	keys := make([]string, len(block))
+
+	for i, line := range block {
+		if hasComment(line) {
+			// Merging would lose the comment
+			continue
+		}
+		kind, importPath := parseImport(line)
+		parent, members, ok := splitGroupUse(importPath)
+		if !ok {
+			continue
+		}
+		prefix := importQualifier(kind) + parent
+		keys[i] = prefix
+		m := byNamespace[prefix]
+		if m == nil {
+			m = &merged{first: i}
+			byNamespace[prefix] = m
+		}
+		for _, member := range members {
+			if !containsString(m.members, member) {
+				m.members = append(m.members, member)
+			}
+		}
+		m.sources++
+		m.grouped = m.grouped || strings.Contains(importPath, "{")
+	}
+
+	var result []string
+	for i, line := range block {
+		m := byNamespace[keys[i]]
+		if m == nil || (m.sources < 2 && !(m.grouped && maxWidth > 0)) {
+			result = append(result, line)
+			continue
+		}
+		if i != m.first {
+			continue
+		}
+		sort.Strings(m.members)
+		indent, _ := splitIndent(line)
+		merged := fmt.Sprintf("%suse %s\\{%s};", indent, keys[i], strings.Join(m.members, ", "))
+		if maxWidth > 0 && utf8.RuneCountInString(merged) > maxWidth {
+			var wrapped strings.Builder
+			fmt.Fprintf(&wrapped, "%suse %s\\{\n", indent, keys[i])
+			for _, member := range m.members {
+				fmt.Fprintf(&wrapped, "%s    %s,\n", indent, member)
+			}
+			wrapped.WriteString(indent + "};")
+			merged = wrapped.String()
+		}
+		result = append(result, merged)
+	}
+	return result
+}
+
+// expandGroupUse replaces each group use declaration with one import per
+// member, so `use App\Models\{User as U, Post};` becomes
+// `use App\Models\User as U;` and `use App\Models\Post;`. A qualifier on the
+// declaration or on a member carries over to the expanded import.
+func expandGroupUse(block []string) []string {
+	var result []string
+	for _, line := range block {
+		kind, importPath := parseImport(line)
+		open := strings.Index(importPath, "{")
+		closing := strings.LastIndex(importPath, "}")
+		if open < 0 || closing < open || hasComment(line) {
+			// Expanding would lose the comment
+			result = append(result, line)
+			continue
+		}
+		parent := strings.TrimSpace(importPath[:open])
+		indent, _ := splitIndent(line)
+		expanded := len(result)
+		for _, member := range strings.Split(importPath[open+1:closing], ",") {
+			member = strings.Join(strings.Fields(member), " ")
+			if member == "" {
+				continue
+			}
+			qualifier := importQualifier(kind)
+			for _, k := range []importKind{kindFunction, kindConst} {
+				if strings.HasPrefix(member, importQualifier(k)) {
+					qualifier = importQualifier(k)
+					member = strings.TrimPrefix(member, qualifier)
+				}
+			}
+			result = append(result, fmt.Sprintf("%suse %s%s%s;", indent, qualifier, parent, member))
+		}
+		if len(result) == expanded {
+			// An empty group use, left for PHP to report
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// sortGroupMembers sorts the members within the braces of each group use,
+// so `use App\{C, B as X, A};` becomes `use App\{A, B as X, C};`. A
+// declaration written with one member per line keeps that layout. Group uses
+// with comments inside the braces, or with several members on a line of a
+// multi-line declaration, are left as they are.
+func sortGroupMembers(block []string, config *Config) {
+	for i, line := range block {
+		comments, statement := splitAttachedComments(line)
+		open := strings.Index(statement, "{")
+		closing := strings.LastIndex(statement, "}")
+		if open < 0 || closing < open {
+			continue
+		}
+		inner := statement[open+1 : closing]
+		if strings.Contains(inner, "//") || strings.Contains(inner, "#") || strings.Contains(inner, "/*") {
+			continue
+		}
+		var sorted string
+		var ok bool
+		if strings.Contains(inner, "\n") {
+			sorted, ok = sortMemberLines(inner, config)
+		} else {
+			sorted, ok = sortMemberList(inner, config)
+		}
+		if ok {
+			block[i] = comments + statement[:open+1] + sorted + statement[closing:]
+		}
+	}
+}
+
+// sortMemberList sorts the members of a single-line group use, keeping the
+// spaces inside the braces and a trailing comma.
+func sortMemberList(inner string, config *Config) (string, bool) {
+	content := strings.TrimSpace(inner)
+	start := strings.Index(inner, content)
+	trailingComma := strings.HasSuffix(content, ",")
+	var members []string
+	for _, member := range strings.Split(strings.TrimSuffix(content, ","), ",") {
+		member = strings.Join(strings.Fields(member), " ")
+		if member == "" {
+			return "", false
+		}
+		members = append(members, member)
+	}
+	sortMembers(members, config)
+	sorted := strings.Join(members, ", ")
+	if trailingComma {
+		sorted += ","
+	}
+	return inner[:start] + sorted + inner[start+len(content):], true
+}
+
+// sortMemberLines sorts the members of a group use written with one member
+// per line, keeping each line's indentation and whether the last member has
+// a trailing comma.
+func sortMemberLines(inner string, config *Config) (string, bool) {
+	lines := strings.Split(inner, "\n")
+	first, last := lines[0], lines[len(lines)-1]
+	if strings.TrimSpace(first) != "" || strings.TrimSpace(last) != "" || len(lines) < 3 {
+		return "", false
+	}
+	members := lines[1 : len(lines)-1]
+	indents := make([]string, len(members))
+	names := make([]string, len(members))
+	trailingComma := false
+	for i, line := range members {
+		indent, member := splitIndent(strings.TrimRight(line, " \t"))
+		hasComma := strings.HasSuffix(member, ",")
+		member = strings.TrimSpace(strings.TrimSuffix(member, ","))
+		if member == "" || strings.Contains(member, ",") || (!hasComma && i < len(members)-1) {
+			return "", false
+		}
+		indents[i], names[i] = indent, member
+		trailingComma = hasComma
+	}
+	sortMembers(names, config)
+	for i := range members {
+		members[i] = indents[i] + names[i]
+		if i < len(members)-1 || trailingComma {
+			members[i] += ","
+		}
+	}
+	return strings.Join(append(append([]string{first}, members...), last), "\n"), true
+}
+
+// sortMembers orders group use members like the imports of a block: by kind
+// unless import_types is "interleave", then by sort key.
+func sortMembers(members []string, config *Config) {
+	byType := config.ImportTypes != "interleave"
+	sort.SliceStable(members, func(i, j int) bool {
+		kindI, nameI := parseImport("use " + members[i] + ";")
+		kindJ, nameJ := parseImport("use " + members[j] + ";")
+		if byType && kindI != kindJ {
+			return kindI < kindJ
+		}
+		if keyI, keyJ := sortKey(nameI, config), sortKey(nameJ, config); keyI != keyJ {
+			return keyI < keyJ
+		}
+		return members[i] < members[j]
+	})
+}
+
+// splitGroupUse splits an import path such as `App\Models\User as U` or
+// `App\Models\{Post, Comment}` into its parent namespace and member names.
+// It reports false for imports that cannot be part of a group use.
+func splitGroupUse(importPath string) (string, []string, bool) {
+	if open := strings.Index(importPath, "{"); open >= 0 {
+		closing := strings.LastIndex(importPath, "}")
+		if closing < open {
+			return "", nil, false
+		}
+		parent := strings.TrimSuffix(strings.TrimSpace(importPath[:open]), "\\")
+		var members []string
+		for _, member := range strings.Split(importPath[open+1:closing], ",") {
+			member = strings.Join(strings.Fields(member), " ")
+			if member == "" {
+				continue
+			}
+			if strings.HasPrefix(member, "function ") || strings.HasPrefix(member, "const ") {
+				return "", nil, false
+			}
+			members = append(members, member)
+		}
+		return parent, members, parent != "" && len(members) > 0
+	}
+
+	sep := strings.LastIndex(importName(importPath), "\\")
+	if sep <= 0 {
+		return "", nil, false
+	}
+	return importPath[:sep], []string{strings.Join(strings.Fields(importPath[sep+1:]), " ")}, true
+}
+
+// importName returns the name an import path starts with, without its
+// alias, or "" for an empty statement such as a half-typed `use ;`.
+func importName(importPath string) string {
+	fields := strings.Fields(importPath)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// importQualifier returns the keyword written after `use` for a kind.
+func importQualifier(kind importKind) string {
+	switch kind {
+	case kindFunction:
+		return "function "
+	case kindConst:
+		return "const "
+	}
+	return ""
+}
+
+// sortKey transforms an import path into the string compared when ordering
+// imports within a group.
+func sortKey(importPath string, config *Config) string {
+	if config.SortByAlias {
+		importPath = referencedName(importPath)
+	}
+	if config.CaseSensitive != nil && !*config.CaseSensitive {
+		// Fold before the replacements below so they still apply
+		importPath = strings.ToLower(importPath)
+	}
+	if config.SeparatorSortsFirst {
+		// Make `\` order before any other character, so that App\Sub\Thing
+		// sorts before AppBar
+		importPath = strings.ReplaceAll(importPath, "\\", "\x00")
+	}
+	switch config.UnderscoreOrder {
+	case "first":
+		// After the separator but before digits and letters
+		importPath = strings.ReplaceAll(importPath, "_", "\x01")
+	case "last":
+		// After all ASCII letters
+		importPath = strings.ReplaceAll(importPath, "_", "\x7f")
+	}
+	return importPath
+}
+
+// referencedName returns the name an import is referenced by in code: its
+// alias (`as` is matched case-insensitively) or else its last segment. Group
+// uses have no single name and are returned unchanged.
+func referencedName(importPath string) string {
+	if strings.Contains(importPath, "{") {
+		return importPath
+	}
+	fields := strings.Fields(importPath)
+	if len(fields) == 3 && strings.EqualFold(fields[1], "as") {
+		return fields[2]
+	}
+	if len(fields) == 0 {
+		return importPath
+	}
+	return fields[0][strings.LastIndex(fields[0], "\\")+1:]
+}
+
+// sortRank returns the primary key within a group for the sort_by mode:
+// the number of namespace segments for "depth", the length of the import
+// for "length", and 0 for "alpha", leaving the order alphabetical.
+func sortRank(importPath, sortBy string) int {
+	switch sortBy {
+	case "depth":
+		return strings.Count(strings.TrimPrefix(importPath, "\\"), "\\") + 1
+	case "length":
+		return len(importPath)
+	}
+	return 0
+}
+
+// groupOrder returns the sort_by and whether the order is descending for the
+// imports of a group, which may set its own instead of the config's.
+func (c *Config) groupOrder(index int) (string, bool) {
+	if index < 0 || index >= len(c.Groups) {
+		return c.SortBy, false
+	}
+	group := c.Groups[index]
+	if group.SortBy != "" {
+		return group.SortBy, group.Order == "desc"
+	}
+	return c.SortBy, group.Order == "desc"
+}
+
+// groupSpacing returns how many blank lines separate two groups:
+// blank_lines_between_groups if set, else 1 under newline_between_groups.
+func (c *Config) groupSpacing() int {
+	if c.BlankLinesBetweenGroups != nil {
+		return *c.BlankLinesBetweenGroups
+	}
+	if c.NewlineBetweenGroups {
+		return 1
+	}
+	return 0
+}
+
+// transformsSortKey reports whether any option changes sortKey, otherwise
+// imports are compared by their raw line.
+func (c *Config) transformsSortKey() bool {
+	return c.SeparatorSortsFirst || c.UnderscoreOrder == "first" || c.UnderscoreOrder == "last" || c.SortByAlias ||
+		(c.CaseSensitive != nil && !*c.CaseSensitive)
+}
+
+// parseNamespace returns the name from a `namespace X;` or `namespace X {`
+// declaration.
+func parseNamespace(trimmed string) string {
+	name := strings.TrimSpace(strings.TrimPrefix(trimmed, "namespace "))
+	name = strings.TrimSpace(strings.TrimRight(name, ";{"))
+	return strings.TrimPrefix(name, "\\")
+}
+
+// reportSameNamespace warns about class imports directly inside the file's
+// own namespace, which resolve without a use statement.
+func reportSameNamespace(block []string, opts *Options, filePath, namespace string) {
+	if namespace == "" {
+		return
+	}
+	for _, line := range block {
+		kind, importPath := parseImport(line)
+		if kind != kindClass || strings.Contains(importPath, " ") {
+			// Aliased imports are not redundant
+			continue
+		}
+		name := strings.TrimPrefix(importPath, "\\")
+		if strings.HasPrefix(name, namespace+"\\") && !strings.Contains(name[len(namespace)+1:], "\\") {
+			opts.warnf("%s: %s is in the file's own namespace %s", filePath, name, namespace)
+		}
+	}
+}
+
+// bucketLetter returns the uppercased first letter of an import path after
+// removing the prefix of the group it belongs to, for alphabetical_buckets.
+func bucketLetter(importPath string, index int, groups []Group, namespace string) rune {
+	importPath = strings.TrimPrefix(importPath, "\\")
+	if index >= 0 && index < len(groups) {
+		switch prefix := groups[index].Prefix; prefix {
+		case "*":
+		case sameNamespaceGroup:
+			importPath = strings.TrimPrefix(importPath, namespace+"\\")
+		case composerGroup:
+			importPath = strings.TrimPrefix(importPath, groups[index].composerPrefix(importPath))
+		default:
+			importPath = strings.TrimPrefix(importPath, prefix)
+		}
+	}
+	for _, r := range importPath {
+		if unicode.IsLetter(r) {
+			return unicode.ToUpper(r)
+		}
+	}
+	return 0
+}
+
+// writeGroupHeader emits the configured header comment of a group, if any.
+func writeGroupHeader(w *bytes.Buffer, index int, groups []Group) error {
+	if index < 0 || index >= len(groups) || groups[index].Header == "" {
+		return nil
+	}
+	_, err := w.WriteString(groups[index].Header + "\n")
+	return err
+}
+
+// isGroupHeader reports whether a trimmed source line is one of the configured
+// group headers, so existing headers are replaced rather than duplicated.
+func isGroupHeader(trimmed string, groups []Group) bool {
+	if trimmed == "" {
+		return false
+	}
+	for _, group := range groups {
+		if group.Header != "" && strings.TrimSpace(group.Header) == trimmed {
+			return true
+		}
+	}
+	return false
+}
+
+// isCommentLine reports whether a trimmed line starts a `//`, `#` or `/* */`
+// comment. A `#[` attribute is not a comment.
+func isCommentLine(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*") ||
+		(strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "#["))
+}
+
+// splitAttachedComments splits a use block line into the comment lines
+// attached above the import, including their final newline, and the use
+// statement itself.
+func splitAttachedComments(line string) (string, string) {
+	offset := 0
+	for _, physical := range strings.SplitAfter(line, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(physical), "use ") {
+			return line[:offset], line[offset:]
+		}
+		offset += len(physical)
+	}
+	return "", line
+}
+
+// splitIndent splits a use statement into the spaces and tabs indenting it
+// and the statement itself, so that rewriting the statement keeps the
+// original indentation.
+func splitIndent(statement string) (string, string) {
+	content := strings.TrimLeft(statement, " \t")
+	return statement[:len(statement)-len(content)], content
+}
+
+// importStatement returns the trimmed use statement of a use block line,
+// without attached or trailing comments.
+func importStatement(line string) string {
+	_, statement := splitAttachedComments(line)
+	return stripTrailingComment(strings.TrimSpace(statement))
+}
+
+// stripTrailingComment removes a `//`, `#` or `/* */` comment following the
+// terminating `;` of a trimmed use statement, e.g.
+// `use App\Legacy\Thing; // TODO remove in v3`.
+func stripTrailingComment(trimmed string) string {
+	end := strings.Index(trimmed, ";")
+	if end < 0 {
+		return trimmed
+	}
+	rest := strings.TrimSpace(trimmed[end+1:])
+	if strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "/*") {
+		return trimmed[:end+1]
+	}
+	return trimmed
+}
+
+// hasComment reports whether a use statement carries a comment, after its `;`
+// or on lines attached above it.
+func hasComment(line string) bool {
+	comments, statement := splitAttachedComments(line)
+	trimmed := strings.TrimSpace(statement)
+	return comments != "" || stripTrailingComment(trimmed) != trimmed
+}
+
+// parseImport extracts the import path from a use line (removing "use " and
+// ";") and reports whether it is a class, function or const import.
+func parseImport(line string) (importKind, string) {
+	importPath := strings.TrimSuffix(strings.TrimPrefix(importStatement(line), "use "), ";")
+	if strings.HasPrefix(importPath, "function ") {
+		return kindFunction, strings.TrimSpace(strings.TrimPrefix(importPath, "function "))
+	}
+	if strings.HasPrefix(importPath, "const ") {
+		return kindConst, strings.TrimSpace(strings.TrimPrefix(importPath, "const "))
+	}
+	return kindClass, importPath
+}
+
+// getGroupIndex returns the index of the group an import is sorted into, see
+// matchGroup.
+func getGroupIndex(kind importKind, importPath string, config *Config, namespace string) int {
+	index, _ := matchGroup(kind, importPath, config, namespace)
+	return index
+}
+
+// pinnedGroup is the group index of pinned imports, before all groups.
+const pinnedGroup = -1
+
+// matchGroup returns the group index of an import along with a description of
+// the matcher that selected it, for Explain. Specific groups always win
+// over `*`, which only collects the imports no other group matches, wherever
+// it is in the list, and among them the longest matching prefix wins.
+// Without a `*`, unmatched imports go after all groups.
+func matchGroup(kind importKind, importPath string, config *Config, namespace string) (int, string) {
+	groups, priority := config.Groups, config.GroupPriority
+	if pinnedRank(importPath, config.Pinned) < len(config.Pinned) {
+		return pinnedGroup, "pinned, placed before all groups"
+	}
+	if len(groups) == 0 {
+		return 0, "no groups configured"
+	}
+
+	var matches []int
+	for i, group := range groups {
+		if group.matches(kind, importPath, namespace) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 0 {
+		best := matches[0]
+		if len(priority) > 0 {
+			// Overlapping groups are resolved by group_priority, groups not
+			// listed there rank after those that are
+			for _, i := range matches[1:] {
+				if priorityRank(groups[i], priority) < priorityRank(groups[best], priority) {
+					best = i
+				}
+			}
+		} else {
+			candidates := preferredMatches(matches, groups, config.KindGroupPrecedence == "kind")
+			best = candidates[0]
+			// The most specific group wins, so `App\Tests\` takes its imports
+			// from `App\` wherever the two are listed. Imports from the file's
+			// own namespace take precedence over prefix groups.
+			for _, i := range candidates[1:] {
+				if groups[i].specificity(importPath) > groups[best].specificity(importPath) {
+					best = i
+				}
+			}
+			for _, i := range candidates {
+				if groups[i].Prefix == sameNamespaceGroup {
+					best = i
+					break
+				}
+			}
+		}
+		reason := groups[best].describe(namespace)
+		if len(matches) > 1 {
+			reason += fmt.Sprintf(", chosen among %d matching groups", len(matches))
+		}
+		return best, reason
+	}
+
+	// A wildcard for the import's kind comes before the one for any kind
+	wildcard := -1
+	for i, group := range groups {
+		if group.Prefix != "*" {
+			continue
+		}
+		if group.Kind == kind.String() {
+			return i, fmt.Sprintf("wildcard `*` for %s imports", kind)
+		}
+		if group.Kind == "" && wildcard < 0 {
+			wildcard = i
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard, "wildcard `*`"
+	}
+	return len(groups), "no match, placed after all groups"
+}
+
+// preferredMatches narrows the groups matching an import to its kind group
+// or to the others, following kind_group_precedence, when it matches both.
+func preferredMatches(matches []int, groups []Group, kindFirst bool) []int {
+	var byKind, others []int
+	for _, i := range matches {
+		if _, ok := kindGroups[groups[i].Prefix]; ok {
+			byKind = append(byKind, i)
+		} else {
+			others = append(others, i)
+		}
+	}
+	switch {
+	case len(byKind) == 0 || len(others) == 0:
+		return matches
+	case kindFirst:
+		return byKind
+	}
+	return others
+}
+
+// kindGroups are the group tokens matching every import of a kind, such as
+// `function:` for all `use function` imports.
+var kindGroups = map[string]importKind{
+	"class:":    kindClass,
+	"function:": kindFunction,
+	"const:":    kindConst,
+}
+
+// containsPrefix marks a group matching imports that contain a substring
+// anywhere, e.g. `contains:\Controller`.
+const containsPrefix = "contains:"
+
+// regexPrefix marks a group matching imports against a regular expression,
+// e.g. `re:\\Tests\\`. The pattern is compiled by LoadConfig.
+const regexPrefix = "re:"
+
+// matches reports whether a specific (non-wildcard) group matches an import.
+func (g Group) matches(kind importKind, importPath, namespace string) bool {
+	if g.Kind != "" && g.Kind != kind.String() {
+		return false
+	}
+	if groupKind, ok := kindGroups[g.Prefix]; ok {
+		return kind == groupKind
+	}
+	switch {
+	case g.Prefix == "*":
+		return false
+	case g.Prefix == sameNamespaceGroup:
+		return namespace != "" && strings.HasPrefix(importPath, namespace+"\\")
+	case g.Prefix == composerGroup:
+		return g.composerPrefix(importPath) != ""
+	case strings.HasPrefix(g.Prefix, containsPrefix):
+		return strings.Contains(importPath, strings.TrimPrefix(g.Prefix, containsPrefix))
+	case g.re != nil:
+		return g.re.MatchString(importPath)
+	}
+	return strings.HasPrefix(importPath, g.Prefix)
+}
+
+// specificity ranks groups matching the same import: the length of the
+// prefix it matched, or 0 for contains:, re: and kind groups.
+func (g Group) specificity(importPath string) int {
+	if _, ok := kindGroups[g.Prefix]; ok {
+		return 0
+	}
+	switch {
+	case g.Prefix == composerGroup:
+		return len(g.composerPrefix(importPath))
+	case strings.HasPrefix(g.Prefix, containsPrefix), g.re != nil:
+		return 0
+	}
+	return len(g.Prefix)
+}
+
+// composerPrefix returns the PSR-4 prefix of a <composer> group that an
+// import is under, or "".
+func (g Group) composerPrefix(importPath string) string {
+	for _, prefix := range g.prefixes {
+		if strings.HasPrefix(importPath, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// describe names the matcher of a group for Explain.
+func (g Group) describe(namespace string) string {
+	if g.Kind != "" {
+		return fmt.Sprintf("%s, %s imports only", g.describeMatcher(namespace), g.Kind)
+	}
+	return g.describeMatcher(namespace)
+}
+
+// describeMatcher names the matcher of a group, leaving out its kind.
+func (g Group) describeMatcher(namespace string) string {
+	if kind, ok := kindGroups[g.Prefix]; ok {
+		return fmt.Sprintf("%s imports", kind)
+	}
+	switch {
+	case g.Prefix == sameNamespaceGroup:
+		return fmt.Sprintf("same namespace `%s`", namespace)
+	case g.Prefix == composerGroup:
+		return fmt.Sprintf("composer.json PSR-4 namespaces `%s`", strings.Join(g.prefixes, "`, `"))
+	case strings.HasPrefix(g.Prefix, containsPrefix):
+		return fmt.Sprintf("contains `%s`", strings.TrimPrefix(g.Prefix, containsPrefix))
+	case g.re != nil:
+		return fmt.Sprintf("regex `%s`", g.re)
+	}
+	return fmt.Sprintf("prefix `%s`", g.Prefix)
+}
+
+// priorityRank returns the position of a group in group_priority, or
+// len(priority) if it is not listed.
+func priorityRank(g Group, priority []string) int {
+	for i, p := range priority {
+		if p == g.Prefix {
+			return i
+		}
+	}
+	return len(priority)
+}
+
+// Explain describes which group an import such as
+// `App\Http\Controllers\UserController` or `function App\helper` lands in,
+// the matcher that selected it and its sort key.
+func Explain(w io.Writer, importPath string, config *Config) {
+	line := "use " + strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(importPath), "use "), ";") + ";"
+	kind, path := parseImport(line)
+	index, matcher := matchGroup(kind, path, config, "")
+
+	fmt.Fprintf(w, "Import:     %s\n", path)
+	fmt.Fprintf(w, "Kind:       %s\n", kind)
+	fmt.Fprintf(w, "Matched by: %s\n", matcher)
+	fmt.Fprintf(w, "Group:      %d\n", index)
+	fmt.Fprintf(w, "Sort key:   %q\n", sortKey(path, config))
+
+	if len(config.Groups) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Groups:\n")
+	for i, group := range config.Groups {
+		marker := " "
+		if i == index {
+			marker = ">"
+		}
+		if group.Kind != "" {
+			fmt.Fprintf(w, "  %s %d  %s (%s)\n", marker, i, group.Prefix, group.Kind)
+			continue
+		}
+		fmt.Fprintf(w, "  %s %d  %s\n", marker, i, group.Prefix)
+	}
+	if index == len(config.Groups) {
+		fmt.Fprintf(w, "  > %d  (unmatched)\n", index)
+	}
+}
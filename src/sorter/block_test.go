@@ -0,0 +1,371 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEmptyUseStatement(t *testing.T) {
+	// Half-typed statements, as an editor sends them in filter mode, are
+	// sorted around and never crash the comparator
+	src := "<?php\nuse B;\nuse ;\nuse function ;\nuse App\\{};\nuse A;\n"
+	configs := map[string]string{
+		"default":         `{}`,
+		"order_overrides": `{"order_overrides": {"first": ["A"], "last": ["B"]}}`,
+		"freeze_imports":  `{"freeze_imports": ["A"]}`,
+		"pinned":          `{"pinned": ["B"]}`,
+		"collapse":        `{"group_use": "collapse"}`,
+		"expand":          `{"group_use": "expand"}`,
+		"normalize":       `{"normalize_whitespace": true, "strip_leading_backslash": true}`,
+	}
+	for name, content := range configs {
+		t.Run(name, func(t *testing.T) {
+			got := sortSource(t, loadTestConfig(t, content), nil, src)
+			for _, line := range []string{"use ;\n", "use function ;\n", "use App\\{};\n", "use A;\n", "use B;\n"} {
+				if !strings.Contains("\n"+got, "\n"+line) {
+					t.Errorf("output lost %q:\n%s", line, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupMatching(t *testing.T) {
+	const overlapping = `"groups": ["*", "App\\Http\\", "contains:\\Controller"]`
+	tests := []struct {
+		name      string
+		config    string
+		kind      importKind
+		path      string
+		namespace string
+		want      int
+	}{
+		{"longest prefix", `{"groups": ["App\\", "App\\Tests\\"]}`, kindClass, `App\Tests\FooTest`, "", 1},
+		{"longest prefix listed first", `{"groups": ["App\\Tests\\", "App\\"]}`, kindClass, `App\Tests\FooTest`, "", 0},
+		{"wildcard catches the rest", `{"groups": ["App\\", "*"]}`, kindClass, `Vendor\Lib`, "", 1},
+		{"wildcard loses to any match", `{"groups": ["*", "contains:Lib"]}`, kindClass, `Vendor\Lib`, "", 1},
+		{"no wildcard", `{"groups": ["App\\"]}`, kindClass, `Vendor\Lib`, "", 1},
+		{"prefix beats contains", `{` + overlapping + `}`, kindClass, `App\Http\Controllers\UserController`, "", 1},
+		{"contains only", `{` + overlapping + `}`, kindClass, `Admin\Controller\Users`, "", 2},
+		{"first of contains and re", `{"groups": ["re:Controller$", "contains:Controller"]}`, kindClass, `App\UserController`, "", 0},
+		{"priority overrides prefix", `{` + overlapping + `, "group_priority": ["contains:\\Controller"]}`, kindClass, `App\Http\Controllers\UserController`, "", 2},
+		{"priority without overlap", `{` + overlapping + `, "group_priority": ["contains:\\Controller"]}`, kindClass, `App\Http\Request`, "", 1},
+		{"listed priority beats unlisted", `{` + overlapping + `, "group_priority": ["App\\Http\\"]}`, kindClass, `App\Http\Controllers\UserController`, "", 1},
+		{"priority order", `{` + overlapping + `, "group_priority": ["contains:\\Controller", "App\\Http\\"]}`, kindClass, `App\Http\Controllers\UserController`, "", 2},
+		{"same namespace wins", `{"groups": ["App\\Http\\Controllers\\", "<same_namespace>"]}`, kindClass, `App\Http\Controllers\Base`, `App\Http\Controllers`, 1},
+		{"same namespace elsewhere", `{"groups": ["App\\", "<same_namespace>"]}`, kindClass, `App\Models\User`, `App\Http`, 0},
+		{"kind wildcard", `{"groups": ["*", "function:*"]}`, kindFunction, `App\helper`, "", 1},
+		{"kind wildcard skips classes", `{"groups": ["*", "function:*"]}`, kindClass, `App\Helper`, "", 0},
+		{"kind of a prefix group", `{"groups": [{"prefix": "App\\", "kind": "const"}, "*"]}`, kindFunction, `App\helper`, "", 1},
+		{"pinned", `{"groups": ["App\\"], "pinned": ["App\\Boot"]}`, kindClass, `App\Boot`, "", pinnedGroup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, tt.config)
+			if got := getGroupIndex(tt.kind, tt.path, config, tt.namespace); got != tt.want {
+				_, reason := matchGroup(tt.kind, tt.path, config, tt.namespace)
+				t.Errorf("group = %d (%s), want %d", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollapseMaxLineWidth(t *testing.T) {
+	// `use App\Very\Long\{A, B, C, D};` is 31 characters
+	const long = "<?php\nuse App\\Very\\Long\\{A, B, C, D};\n"
+	const wrapped = "<?php\nuse App\\Very\\Long\\{\n    A,\n    B,\n    C,\n    D,\n};\n"
+	tests := []struct {
+		name  string
+		width int
+		src   string
+		want  string
+	}{
+		{"lone group use too long", 20, long, wrapped},
+		{"lone group use one over", 30, long, wrapped},
+		{"lone group use fits exactly", 31, long, long},
+		{"lone group use unlimited", 0, long, long},
+		{"lone wrapped group use fits", 40, wrapped, long},
+		{"lone wrapped group use unlimited", 0, wrapped, wrapped},
+		{"merged fits", 15, "<?php\nuse App\\B;\nuse App\\A;\n", "<?php\nuse App\\{A, B};\n"},
+		{"merged too long", 14, "<?php\nuse App\\B;\nuse App\\A;\n", "<?php\nuse App\\{\n    A,\n    B,\n};\n"},
+		{"merged into a group use", 20, "<?php\nuse App\\Very\\Long\\{A, B};\nuse App\\Very\\Long\\C;\n", "<?php\nuse App\\Very\\Long\\{\n    A,\n    B,\n    C,\n};\n"},
+		{"single import", 5, "<?php\nuse App\\Models\\User;\n", "<?php\nuse App\\Models\\User;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, fmt.Sprintf(`{"group_use": "collapse", "max_line_width": %d}`, tt.width))
+			if got := sortSource(t, config, nil, tt.src); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"identical", "use B;\nuse A;\nuse B;\n", "use A;\nuse B;\n"},
+		{"space before semicolon", "use  Zed ;\nuse Zed;\n", "use  Zed ;\n"},
+		{"trailing comment", "use Zed; // keep\nuse Zed;\n", "use Zed; // keep\n"},
+		{"qualifier spacing", "use  function  App\\helper;\nuse function App\\helper;\n", "use  function  App\\helper;\n"},
+		{"alias casing", "use A\\B AS C;\nuse A\\B as C;\n", "use A\\B AS C;\n"},
+		{"alias kept apart", "use A\\B;\nuse A\\B as C;\nuse A\\B;\n", "use A\\B as C;\nuse A\\B;\n"},
+		{"different aliases", "use A\\B as D;\nuse A\\B as C;\n", "use A\\B as C;\nuse A\\B as D;\n"},
+		{"kinds kept apart", "use function App\\foo;\nuse App\\foo;\n", "use App\\foo;\nuse function App\\foo;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortSource(t, loadTestConfig(t, `{}`), nil, "<?php\n"+tt.src)
+			if got != "<?php\n"+tt.want {
+				t.Errorf("got:\n%s\nwant:\n<?php\n%s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		src := "<?php\nuse B;\nuse A;\nuse B;\n"
+		got := sortSource(t, loadTestConfig(t, `{"remove_duplicates": false}`), nil, src)
+		if want := "<?php\nuse A;\nuse B;\nuse B;\n"; got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
+
+func TestGroupHeaders(t *testing.T) {
+	const src = "<?php\nuse function App\\f;\nuse Z;\nuse App\\B;\nuse const App\\C;\n"
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			"once across kind sections",
+			`{"groups": [{"prefix": "App\\", "header": "// App"}, "*"]}`,
+			"<?php\n// App\nuse App\\B;\nuse Z;\nuse function App\\f;\nuse const App\\C;\n",
+		},
+		{
+			"blank lines between kind sections",
+			`{"groups": [{"prefix": "App\\", "header": "// App"}, {"prefix": "*", "header": "// Vendor"}], "blank_line_between_import_types": true}`,
+			"<?php\n// App\nuse App\\B;\n// Vendor\nuse Z;\n\nuse function App\\f;\n\nuse const App\\C;\n",
+		},
+		{
+			"comment_group_headers",
+			`{"groups": ["App\\", "*"], "comment_group_headers": {"0": "// App", "1": "// Vendor"}}`,
+			"<?php\n// App\nuse App\\B;\n// Vendor\nuse Z;\nuse function App\\f;\nuse const App\\C;\n",
+		},
+		{
+			"interleave",
+			`{"groups": ["App\\", "*"], "comment_group_headers": {"0": "// App"}, "import_types": "interleave"}`,
+			"<?php\n// App\nuse App\\B;\nuse const App\\C;\nuse function App\\f;\nuse Z;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, tt.config)
+			got := sortSource(t, config, nil, src)
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			// Headers already in the file are replaced, not added again
+			if again := sortSource(t, config, nil, got); again != got {
+				t.Errorf("second run changed the output:\n%s", again)
+			}
+		})
+	}
+
+	t.Run("once per block", func(t *testing.T) {
+		// Blocks sorted on their own each get the headers of their groups
+		config := loadTestConfig(t, `{"groups": ["App\\", "*"], "comment_group_headers": {"0": "// App"}, "merge_adjacent_blocks": false}`)
+		got := sortSource(t, config, nil, "<?php\nuse Z;\nuse App\\B;\n\n// note\nuse App\\A;\n")
+		if want := "<?php\n// App\nuse App\\B;\nuse Z;\n\n// note\n// App\nuse App\\A;\n"; got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
+
+func TestFreezeImports(t *testing.T) {
+	tests := []struct {
+		name, config, src, want string
+	}{
+		{
+			"one frozen",
+			`{"freeze_imports": ["App\\Bootstrap\\Early"]}`,
+			"use D;\nuse App\\Bootstrap\\Early;\nuse C;\nuse B;\n",
+			"use B;\nuse App\\Bootstrap\\Early;\nuse C;\nuse D;\n",
+		},
+		{
+			// Each frozen import keeps its index, the rest fill the gaps in order
+			"several frozen",
+			`{"freeze_imports": ["Z\\Last", "Y\\First"]}`,
+			"use Y\\First;\nuse D;\nuse C;\nuse Z\\Last;\nuse B;\nuse A;\n",
+			"use Y\\First;\nuse A;\nuse B;\nuse Z\\Last;\nuse C;\nuse D;\n",
+		},
+		{
+			"namespace prefix",
+			`{"freeze_imports": ["Legacy\\"]}`,
+			"use C;\nuse Legacy\\Two;\nuse Legacy\\One;\nuse A;\n",
+			"use A;\nuse Legacy\\Two;\nuse Legacy\\One;\nuse C;\n",
+		},
+		{
+			"prefix needs the separator",
+			`{"freeze_imports": ["Legacy\\One"]}`,
+			"use C;\nuse Legacy\\OneMore;\nuse A;\n",
+			"use A;\nuse C;\nuse Legacy\\OneMore;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortSource(t, loadTestConfig(t, tt.config), nil, "<?php\n"+tt.src)
+			if got != "<?php\n"+tt.want {
+				t.Errorf("got:\n%s\nwant:\n<?php\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupUseRoundTrip(t *testing.T) {
+	src := "<?php\nuse App\\Models\\{User as U, Post};\nuse function App\\Helpers\\{format, money};\nuse App\\Http\\{Request, function route};\n"
+	// Aliases and qualifiers, on the declaration or a member, are distributed
+	expanded := "<?php\nuse App\\Http\\Request;\nuse App\\Models\\Post;\nuse App\\Models\\User as U;\nuse function App\\Helpers\\format;\nuse function App\\Helpers\\money;\nuse function App\\Http\\route;\n"
+	if got := sortSource(t, loadTestConfig(t, `{"group_use": "expand"}`), nil, src); got != expanded {
+		t.Fatalf("expand:\n%s\nwant:\n%s", got, expanded)
+	}
+	collapsed := "<?php\nuse App\\Http\\Request;\nuse App\\Models\\{Post, User as U};\nuse function App\\Helpers\\{format, money};\nuse function App\\Http\\route;\n"
+	collapse := loadTestConfig(t, `{"group_use": "collapse"}`)
+	if got := sortSource(t, collapse, nil, expanded); got != collapsed {
+		t.Fatalf("collapse:\n%s\nwant:\n%s", got, collapsed)
+	}
+	if got := sortSource(t, loadTestConfig(t, `{"group_use": "expand"}`), nil, collapsed); got != expanded {
+		t.Errorf("expanding the collapsed block:\n%s\nwant:\n%s", got, expanded)
+	}
+}
+
+func TestGroupUseMembers(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			// Classes first, then functions, then constants, each by name
+			"single line",
+			"<?php\nuse App\\{C, B as X, A, function zeta, const MAX, function alpha};\n",
+			"<?php\nuse App\\{A, B as X, C, function alpha, function zeta, const MAX};\n",
+		},
+		{"qualified declaration", "<?php\nuse function App\\{zeta, alpha};\n", "<?php\nuse function App\\{alpha, zeta};\n"},
+		{
+			"multi line",
+			"<?php\nuse App\\{\n    C,\n    B as X,\n    A,\n};\n",
+			"<?php\nuse App\\{\n    A,\n    B as X,\n    C,\n};\n",
+		},
+		{"no trailing comma", "<?php\nuse App\\{\n    C,\n    A\n};\n", "<?php\nuse App\\{\n    A,\n    C\n};\n"},
+		{
+			// Left as written
+			"member comment",
+			"<?php\nuse App\\{\n    C, // keep\n    A,\n};\n",
+			"<?php\nuse App\\{\n    C, // keep\n    A,\n};\n",
+		},
+		{"several members on a line", "<?php\nuse App\\{\n    C, B,\n    A,\n};\n", "<?php\nuse App\\{\n    C, B,\n    A,\n};\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, &Config{}, nil, tt.src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindGroups(t *testing.T) {
+	const src = "<?php\nuse App\\Foo;\nuse function App\\helper;\nuse const App\\MAX;\nuse Vendor\\Lib;\nuse function strlen;\nuse const PHP_EOL;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{
+			"kinds only",
+			`{"import_types": "interleave", "groups": ["const:", "function:", "class:"], "newline_between_groups": true}`,
+			"<?php\nuse const App\\MAX;\nuse const PHP_EOL;\n\nuse function App\\helper;\nuse function strlen;\n\nuse App\\Foo;\nuse Vendor\\Lib;\n",
+		},
+		{
+			// App\helper goes to App\, PHP_EOL matches no group and goes last
+			"prefix precedence",
+			`{"import_types": "interleave", "groups": ["function:", "App\\", "class:"]}`,
+			"<?php\nuse function strlen;\nuse App\\Foo;\nuse const App\\MAX;\nuse function App\\helper;\nuse Vendor\\Lib;\nuse const PHP_EOL;\n",
+		},
+		{
+			"kind precedence",
+			`{"import_types": "interleave", "groups": ["function:", "App\\", "class:"], "kind_group_precedence": "kind"}`,
+			"<?php\nuse function App\\helper;\nuse function strlen;\nuse const App\\MAX;\nuse App\\Foo;\nuse Vendor\\Lib;\nuse const PHP_EOL;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindWildcards(t *testing.T) {
+	const src = "<?php\nuse Vendor\\Lib;\nuse function strlen;\nuse App\\Foo;\nuse const PHP_EOL;\nuse function App\\helper;\nuse const App\\MAX;\nuse Zed;\nuse function array_map;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{
+			// Unmatched classes first, unmatched functions last, unmatched
+			// constants in the plain *
+			"interleave",
+			`{"import_types": "interleave", "groups": ["class:*", "App\\", "*", "function:*"], "newline_between_groups": true}`,
+			"<?php\nuse Vendor\\Lib;\nuse Zed;\n\nuse App\\Foo;\nuse const App\\MAX;\nuse function App\\helper;\n\nuse const PHP_EOL;\n\nuse function array_map;\nuse function strlen;\n",
+		},
+		{
+			"kind-limited group",
+			`{"import_types": "interleave", "groups": ["const:*", {"match": "App\\", "kind": "function"}, "*", "function:*"], "newline_between_groups": true}`,
+			"<?php\nuse const App\\MAX;\nuse const PHP_EOL;\n\nuse function App\\helper;\n\nuse App\\Foo;\nuse Vendor\\Lib;\nuse Zed;\n\nuse function array_map;\nuse function strlen;\n",
+		},
+		{
+			// Within each kind's section
+			"separate",
+			`{"groups": ["App\\", "function:*", "*", "const:*"], "newline_between_groups": true}`,
+			"<?php\nuse App\\Foo;\n\nuse Vendor\\Lib;\nuse Zed;\n\nuse function App\\helper;\n\nuse function array_map;\nuse function strlen;\n\nuse const App\\MAX;\n\nuse const PHP_EOL;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinned(t *testing.T) {
+	const src = "<?php\nuse Zed;\nuse App\\Kernel;\nuse function App\\boot;\nuse App\\Models\\User;\nuse Alpha;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{
+			// In the listed order, skipping the absent App\Missing
+			"some absent",
+			`{"pinned": ["App\\Kernel", "App\\Missing", "Zed"], "groups": ["App\\", "*"], "newline_between_groups": true}`,
+			"<?php\nuse App\\Kernel;\nuse Zed;\n\nuse App\\Models\\User;\n\nuse Alpha;\n\nuse function App\\boot;\n",
+		},
+		{
+			"namespace entry",
+			`{"pinned": ["App\\Missing", "App\\"]}`,
+			"<?php\nuse App\\Kernel;\nuse App\\Models\\User;\nuse function App\\boot;\nuse Alpha;\nuse Zed;\n",
+		},
+		{"any kind", `{"pinned": ["App\\boot"]}`, "<?php\nuse function App\\boot;\nuse Alpha;\nuse App\\Kernel;\nuse App\\Models\\User;\nuse Zed;\n"},
+		{"all absent", `{"pinned": ["App\\Missing"]}`, "<?php\nuse Alpha;\nuse App\\Kernel;\nuse App\\Models\\User;\nuse Zed;\nuse function App\\boot;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
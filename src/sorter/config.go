@@ -0,0 +1,515 @@
+package sorter
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config is the content of psort.json. Load it with LoadConfig, which also
+// compiles `re:` groups, resolves `<composer>` groups and reads
+// normalize_casing_from; a Config built in code needs Compile for these.
+type Config struct {
+	Include                     []string `json:"include"`
+	Exclude                     []string `json:"exclude"`
+	Groups                      []Group  `json:"groups"`
+	NewlineBetweenGroups        bool     `json:"newline_between_groups"`
+	BlankLinesBetweenGroups     *int     `json:"blank_lines_between_groups"`
+	BlankLineBetweenImportTypes bool     `json:"blank_line_between_import_types"`
+	NormalizeCasingFrom         string   `json:"normalize_casing_from"`
+	SeparatorSortsFirst         bool     `json:"separator_sorts_first"`
+	ReportSameNamespace         bool     `json:"report_same_namespace"`
+	WarnOnLongImports           int      `json:"warn_on_long_imports"`
+	BackupSuffix                string   `json:"backup_suffix"`
+	AlphabeticalBuckets         bool     `json:"alphabetical_buckets"`
+	GroupUse                    string   `json:"group_use"`
+	MaxLineWidth                int      `json:"max_line_width"`
+	UnderscoreOrder             string   `json:"underscore_order"`
+	GroupPriority               []string `json:"group_priority"`
+	FreezeImports               []string `json:"freeze_imports"`
+	Pinned                      []string `json:"pinned"`
+	ImportTypes                 string   `json:"import_types"`
+	KindGroupPrecedence         string   `json:"kind_group_precedence"`
+	RemoveDuplicates            *bool    `json:"remove_duplicates"`
+	CaseSensitive               *bool    `json:"case_sensitive"`
+	Concurrency                 int      `json:"concurrency"`
+	PreserveBlankLines          bool     `json:"preserve_blank_lines"`
+	MergeAdjacentBlocks         *bool    `json:"merge_adjacent_blocks"`
+	BlankLineAfterImports       bool     `json:"blank_line_after_imports"`
+	EnsureFinalNewline          bool     `json:"ensure_final_newline"`
+	SortBy                      string   `json:"sort_by"`
+	SortByAlias                 bool     `json:"sort_by_alias"`
+	RespectGitignore            *bool    `json:"respect_gitignore"`
+	StripLeadingBackslash       bool     `json:"strip_leading_backslash"`
+	NormalizeWhitespace         bool     `json:"normalize_whitespace"`
+	SkipGenerated               bool     `json:"skip_generated"`
+	GeneratedMarker             string   `json:"generated_marker"`
+	PostCommand                 string   `json:"post_command"`
+	// OrderOverrides moves exact imports to the start or end of their group
+	OrderOverrides OrderOverrides `json:"order_overrides"`
+	// CommentGroupHeaders sets the Header of groups by index
+	CommentGroupHeaders map[int]string `json:"comment_group_headers"`
+	// Profiles are named sets of options applied over the others by
+	// LoadProfile
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
+
+	// Root is the directory of the config file, which include and exclude
+	// patterns are relative to
+	Root string `json:"-"`
+
+	// classMap maps lowercased class names to their canonical spellings,
+	// loaded from NormalizeCasingFrom
+	classMap map[string][]string
+	// generated is the compiled GeneratedMarker, set when SkipGenerated is
+	generated *regexp.Regexp
+	// compiled is set by Compile
+	compiled bool
+}
+
+// OrderOverrides lists imports, by their exact name, that are sorted before
+// (First) or after (Last) the other imports of their group, in the order
+// they are listed.
+type OrderOverrides struct {
+	First []string `json:"first"`
+	Last  []string `json:"last"`
+}
+
+// defaultGeneratedMarker is the generated_marker used when none is set.
+const defaultGeneratedMarker = `@generated|DO NOT EDIT`
+
+// Group is one entry of the groups list. It is either a plain prefix string
+// or an object with a prefix and a header comment emitted above the group.
+type Group struct {
+	Prefix string `json:"prefix"`
+	// Match is another name for Prefix in the object form, moved to Prefix
+	// by LoadConfig
+	Match  string `json:"match"`
+	Header string `json:"header"`
+	// Kind ("class", "function", "const" or "any") limits the group to the
+	// imports of one kind; a `*` group with a kind only collects the
+	// unmatched imports of that kind
+	Kind string `json:"kind"`
+	// Order ("asc" or "desc") and SortBy order the imports within the group,
+	// SortBy defaulting to the config's
+	Order  string `json:"order"`
+	SortBy string `json:"sort_by"`
+
+	// re is the compiled pattern of a `re:` group
+	re *regexp.Regexp
+	// prefixes are the PSR-4 namespaces of a <composer> group
+	prefixes []string
+}
+
+func (g *Group) UnmarshalJSON(data []byte) error {
+	var prefix string
+	if err := json.Unmarshal(data, &prefix); err == nil {
+		g.Prefix = prefix
+		return nil
+	}
+	type plain Group
+	return json.Unmarshal(data, (*plain)(g))
+}
+
+// sameNamespaceGroup is a group token matching imports under the namespace
+// declared by the file being sorted.
+const sameNamespaceGroup = "<same_namespace>"
+
+// composerGroup is a group token matching the project's own namespaces, the
+// PSR-4 prefixes of composer.json's autoload and autoload-dev sections.
+const composerGroup = "<composer>"
+
+// importKind distinguishes class imports from `use function` and `use const`.
+type importKind int
+
+const (
+	kindClass importKind = iota
+	kindFunction
+	kindConst
+)
+
+func (k importKind) String() string {
+	switch k {
+	case kindFunction:
+		return "function"
+	case kindConst:
+		return "const"
+	}
+	return "class"
+}
+
+// ConfigFileName is the name of the config file looked up by DiscoverConfig.
+const ConfigFileName = "psort.json"
+
+// yamlConfigFileNames are looked up by DiscoverConfig after ConfigFileName.
+var yamlConfigFileNames = []string{"psort.yaml", "psort.yml"}
+
+// DiscoverConfig looks for psort.json, psort.yaml or psort.yml in dir and
+// then in each parent directory up to the filesystem root, like
+// .editorconfig. Within a directory psort.json comes first. If none exists
+// it returns the path of psort.json in dir, so that loading it reports the
+// file as missing.
+func DiscoverConfig(dir string) string {
+	if path := findUp(dir, append([]string{ConfigFileName}, yamlConfigFileNames...)...); path != "" {
+		return path
+	}
+	return filepath.Join(dir, ConfigFileName)
+}
+
+// findUp returns the path of the first file called one of names in dir or
+// one of its parents, or "" if there is none. Names are tried in order in
+// each directory.
+func findUp(dir string, names ...string) string {
+	for current := dir; ; current = filepath.Join(current, "..") {
+		for _, name := range names {
+			path := filepath.Join(current, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		abs, err := filepath.Abs(current)
+		if err != nil || filepath.Dir(abs) == abs {
+			return ""
+		}
+	}
+}
+
+// LoadOptionalConfig is like LoadConfig but returns the default config when
+// the file does not exist. An invalid config is still an error.
+func LoadOptionalConfig(path string) (*Config, error) {
+	config, err := LoadConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	return config, err
+}
+
+// LoadConfig reads, validates and decodes the config file at path, which is
+// read as YAML if it ends in .yaml or .yml and as JSON otherwise. Errors
+// name the file and, where possible, the line of the offending field.
+func LoadConfig(path string) (*Config, error) {
+	return LoadProfile(path, "")
+}
+
+// LoadProfile is like LoadConfig, with the options of the named profile
+// applied over the top-level ones. Without a name, or in a config that
+// defines no profiles, it returns the top-level config.
+func LoadProfile(path, profile string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lineOf := func(field string) int { return fieldLine(data, field) }
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		converted, lines, err := yamlToJSON(data)
+		if err != nil {
+			var ye *yamlError
+			if errors.As(err, &ye) {
+				return nil, fmt.Errorf("%s:%d: %w", path, ye.line, err)
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data = converted
+		lineOf = func(field string) int { return lines[field] }
+	}
+	config, err := parseConfig(data, path, profile)
+	if err != nil {
+		// Point at the line of the offending field where possible
+		var fe *fieldError
+		if errors.As(err, &fe) {
+			if line := lineOf(fe.field); line > 0 {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// parseConfig validates and decodes the content of the config file at path,
+// with the named profile applied.
+func parseConfig(data []byte, path, profile string) (*Config, error) {
+	if err := validateConfig(data); err != nil {
+		return nil, err
+	}
+
+	config := Config{Root: filepath.Dir(path)}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	if profile != "" && len(config.Profiles) > 0 {
+		raw, ok := config.Profiles[profile]
+		if !ok {
+			names := make([]string, 0, len(config.Profiles))
+			for name := range config.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fieldErrorf("profiles", "unknown profile %q (defined: %s)", profile, strings.Join(names, ", "))
+		}
+		// Options the profile sets replace the top-level ones, the others
+		// are kept
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return nil, fieldErrorf(joinPath("profiles", profile), "%v", err)
+		}
+	}
+
+	if err := config.Compile(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Compile checks the options that the schema can't and prepares the config
+// for sorting: it compiles `re:` groups and generated_marker, resolves
+// `<composer>` groups and reads normalize_casing_from, the last two relative
+// to Root. LoadConfig already compiles the config it returns, and compiling
+// again does nothing, so Compile is only needed for a Config built in code.
+func (c *Config) Compile() error {
+	if c.compiled {
+		return nil
+	}
+	if c.ImportTypes == "interleave" && c.BlankLineBetweenImportTypes {
+		return fieldErrorf("blank_line_between_import_types", "requires import_types \"separate\"")
+	}
+
+	indexes := make([]int, 0, len(c.CommentGroupHeaders))
+	for index := range c.CommentGroupHeaders {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		field := fmt.Sprintf("comment_group_headers.%d", index)
+		header := c.CommentGroupHeaders[index]
+		if index < 0 || index >= len(c.Groups) {
+			return fieldErrorf(field, "no group at index %d (%d groups)", index, len(c.Groups))
+		}
+		if existing := c.Groups[index].Header; existing != "" && existing != header {
+			return fieldErrorf(field, "groups[%d] already has header %q", index, existing)
+		}
+		c.Groups[index].Header = header
+	}
+
+	// Index of the `*` group of each kind, "any" for the unqualified one
+	wildcards := make(map[string]int)
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		field := fmt.Sprintf("groups[%d]", i)
+		if g.Match != "" {
+			if g.Prefix != "" {
+				return fieldErrorf(field, "set either \"prefix\" or \"match\", not both")
+			}
+			g.Prefix, g.Match = g.Match, ""
+		}
+		if g.Prefix == "" {
+			return fieldErrorf(field, "requires \"prefix\" or \"match\"")
+		}
+		// `function:*` is short for {"prefix": "*", "kind": "function"}
+		if qualifier, ok := strings.CutSuffix(g.Prefix, ":*"); ok {
+			if _, ok := kindGroups[qualifier+":"]; ok {
+				if g.Kind != "" && g.Kind != qualifier {
+					return fieldErrorf(field, "%q conflicts with kind %q", g.Prefix, g.Kind)
+				}
+				g.Prefix, g.Kind = "*", qualifier
+			}
+		}
+		if g.Kind == "any" {
+			g.Kind = ""
+		}
+		if g.Prefix == "*" {
+			kind := cmp.Or(g.Kind, "any")
+			if previous, ok := wildcards[kind]; ok && g.Kind == "" {
+				return fieldErrorf(field, "duplicate \"*\" group, already groups[%d]", previous)
+			} else if ok {
+				return fieldErrorf(field, "duplicate \"*\" group for %s imports, already groups[%d]", kind, previous)
+			}
+			wildcards[kind] = i
+		}
+		if _, ok := kindGroups[g.Prefix]; ok && c.ImportTypes != "interleave" {
+			return fieldErrorf(fmt.Sprintf("groups[%d]", i), "%q requires import_types \"interleave\"", g.Prefix)
+		}
+		if g.Prefix == composerGroup {
+			var err error
+			g.prefixes, err = loadComposerPrefixes(findUp(c.Root, "composer.json"))
+			if err != nil {
+				return fieldErrorf(fmt.Sprintf("groups[%d]", i), "%v", err)
+			}
+			continue
+		}
+		if !strings.HasPrefix(g.Prefix, regexPrefix) {
+			continue
+		}
+		var err error
+		g.re, err = regexp.Compile(strings.TrimPrefix(g.Prefix, regexPrefix))
+		if err != nil {
+			return fieldErrorf(fmt.Sprintf("groups[%d]", i), "%v", err)
+		}
+	}
+
+	for i, name := range c.OrderOverrides.Last {
+		if containsString(c.OrderOverrides.First, name) {
+			return fieldErrorf(fmt.Sprintf("order_overrides.last[%d]", i), "%q is also in order_overrides.first", name)
+		}
+	}
+
+	for i, p := range c.GroupPriority {
+		if !containsGroup(c.Groups, p) {
+			return fieldErrorf(fmt.Sprintf("group_priority[%d]", i), "%q is not one of the groups", p)
+		}
+	}
+
+	for i, pattern := range c.Include {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fieldErrorf(fmt.Sprintf("include[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	for i, pattern := range c.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fieldErrorf(fmt.Sprintf("exclude[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	if c.NormalizeCasingFrom != "" {
+		source := c.NormalizeCasingFrom
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(c.Root, source)
+		}
+		var err error
+		c.classMap, err = loadClassMap(source)
+		if err != nil {
+			return fieldErrorf("normalize_casing_from", "%v", err)
+		}
+	}
+
+	if c.SkipGenerated {
+		marker := c.GeneratedMarker
+		if marker == "" {
+			marker = defaultGeneratedMarker
+		}
+		var err error
+		c.generated, err = regexp.Compile(marker)
+		if err != nil {
+			return fieldErrorf("generated_marker", "%v", err)
+		}
+	}
+	c.compiled = true
+	return nil
+}
+
+// loadComposerPrefixes returns the PSR-4 namespace prefixes declared in the
+// autoload and autoload-dev sections of a composer.json. Without a
+// composer.json (path is "") there are none.
+func loadComposerPrefixes(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var composer struct {
+		Autoload struct {
+			PSR4 map[string]json.RawMessage `json:"psr-4"`
+		} `json:"autoload"`
+		AutoloadDev struct {
+			PSR4 map[string]json.RawMessage `json:"psr-4"`
+		} `json:"autoload-dev"`
+	}
+	if err := json.Unmarshal(data, &composer); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var prefixes []string
+	for _, psr4 := range []map[string]json.RawMessage{composer.Autoload.PSR4, composer.AutoloadDev.PSR4} {
+		for prefix := range psr4 {
+			// The empty prefix is a fallback for every namespace, not a group
+			if prefix != "" && !containsString(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+// loadClassMap reads the canonical class names from a JSON file. The file is
+// either an array of class names or an object keyed by class name, such as a
+// class map generated from composer's autoload_classmap.php.
+func loadClassMap(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		var byName map[string]json.RawMessage
+		if err := json.Unmarshal(data, &byName); err != nil {
+			return nil, fmt.Errorf("%s: expected an array of class names or an object keyed by class name", path)
+		}
+		for name := range byName {
+			names = append(names, name)
+		}
+	}
+
+	classMap := make(map[string][]string)
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "\\")
+		key := strings.ToLower(name)
+		if !containsString(classMap[key], name) {
+			classMap[key] = append(classMap[key], name)
+		}
+	}
+	for _, candidates := range classMap {
+		sort.Strings(candidates)
+	}
+	return classMap, nil
+}
+
+// Fingerprint identifies everything in the config that affects how files
+// are sorted, including what was resolved while loading it, such as the
+// prefixes of composer.json and the class map. It changes whenever the
+// sorted output could.
+func (c *Config) Fingerprint() string {
+	var composer [][]string
+	for _, g := range c.Groups {
+		composer = append(composer, g.prefixes)
+	}
+	data, _ := json.Marshal(struct {
+		Config   *Config
+		Composer [][]string
+		ClassMap map[string][]string
+	}{c, composer, c.classMap})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func containsGroup(groups []Group, prefix string) bool {
+	for _, group := range groups {
+		if group.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, config, err string
+	}{
+		{"unknown key", "{\n  \"groups\": [\"*\"],\n  \"newline_betwen_groups\": true\n}", `psort.json:3: newline_betwen_groups: unknown option (allowed: `},
+		{"two wildcards", `{"groups": ["*", "App\\", "*"]}`, `psort.json:1: groups[2]: duplicate "*" group, already groups[0]`},
+		{"empty group", `{"groups": ["App\\", ""]}`, `psort.json:1: groups[1]: must not be empty`},
+		{"bad include glob", `{"include": ["src/[.php"]}`, `psort.json:1: include[0]: invalid pattern "src/[.php": syntax error in pattern`},
+		{"bad exclude glob", "{\n  \"exclude\": [\n    \"vendor\",\n    \"a[b\"\n  ]\n}", `psort.json:4: exclude[1]: invalid pattern "a[b": syntax error in pattern`},
+		{"invalid regex", `{"groups": ["App\\", "re:("]}`, "psort.json:1: groups[1]: error parsing regexp: missing closing ): `(`"},
+		{"kind group without interleave", `{"groups": ["const:", "function:", "class:"]}`, `psort.json:1: groups[0]: "const:" requires import_types "interleave"`},
+		{"kind group with separate", `{"groups": ["*", "function:"], "import_types": "separate"}`, `psort.json:1: groups[1]: "function:" requires import_types "interleave"`},
+		{"invalid generated_marker", `{"skip_generated": true, "generated_marker": "[a-"}`, `psort.json:1: generated_marker: error parsing regexp: missing closing ]: `},
+		{"invalid unselected profile", `{"profiles": {"invalid": {"sort_by": "size"}}}`, `psort.json:1: profiles.invalid.sort_by: invalid value "size"`},
+		{"nested profiles", `{"profiles": {"x": {"profiles": {}}}}`, `psort.json:1: profiles.x.profiles: profiles cannot be nested`},
+		{"type blank lines with interleave", `{"import_types": "interleave", "blank_line_between_import_types": true}`, `blank_line_between_import_types: requires import_types "separate"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ConfigFileName)
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadConfig: err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	const content = `{
+  "groups": ["App\\", "*"],
+  "newline_between_groups": true,
+  "profiles": {
+    "legacy": {"groups": ["*"], "sort_by": "length"}
+  }
+}`
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const src = "<?php\nuse Zed\\Long;\nuse App\\Foo;\nuse Abc;\n"
+	tests := []struct {
+		profile, want string
+	}{
+		{"", "<?php\nuse App\\Foo;\n\nuse Abc;\nuse Zed\\Long;\n"},
+		// groups is replaced, newline_between_groups kept from the top level
+		{"legacy", "<?php\nuse Abc;\nuse App\\Foo;\nuse Zed\\Long;\n"},
+	}
+	for _, tt := range tests {
+		t.Run("profile "+tt.profile, func(t *testing.T) {
+			config, err := LoadProfile(path, tt.profile)
+			if err != nil {
+				t.Fatalf("LoadProfile: %v", err)
+			}
+			if !config.NewlineBetweenGroups {
+				t.Error("newline_between_groups was not kept")
+			}
+			if got := sortSource(t, config, nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+	if _, err := LoadProfile(path, "nope"); err == nil || !strings.Contains(err.Error(), `profiles: unknown profile "nope" (defined: legacy)`) {
+		t.Errorf("LoadProfile: err = %v, want an unknown profile error", err)
+	}
+}
@@ -0,0 +1,321 @@
+package sorter
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configSchema is the JSON schema psort.json is validated against before it
+// is decoded, so typos and wrong types are reported instead of ignored.
+//
+//go:embed psort.schema.json
+var configSchema []byte
+
+// schema is the subset of JSON schema used by psort.schema.json.
+type schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	PropertyNames        *schema            `json:"propertyNames"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []string           `json:"enum"`
+	OneOf                []*schema          `json:"oneOf"`
+	Minimum              *float64           `json:"minimum"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+}
+
+// additional is the value of additionalProperties: false to reject keys
+// that are not in properties, or the schema their values must match.
+type additional struct {
+	allowed bool
+	schema  *schema
+}
+
+func (a *additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// fieldError is a config error about one field, named as in
+// `groups[1].prefix`.
+type fieldError struct {
+	field string
+	msg   string
+}
+
+func (e *fieldError) Error() string {
+	return e.field + ": " + e.msg
+}
+
+func fieldErrorf(path, format string, args ...interface{}) error {
+	return &fieldError{field: fieldName(path), msg: fmt.Sprintf(format, args...)}
+}
+
+// validateConfig checks raw config JSON against the embedded schema and
+// returns an error naming the offending field and the allowed values.
+func validateConfig(data []byte) error {
+	var root schema
+	if err := json.Unmarshal(configSchema, &root); err != nil {
+		return fmt.Errorf("invalid embedded schema: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if err := root.validate("", value); err != nil {
+		return err
+	}
+
+	// Each profile is checked against the same schema as the top level
+	profiles, _ := value.(map[string]interface{})["profiles"].(map[string]interface{})
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := joinPath("profiles", name)
+		if options, ok := profiles[name].(map[string]interface{}); ok && options["profiles"] != nil {
+			return fieldErrorf(joinPath(path, "profiles"), "profiles cannot be nested")
+		}
+		if err := root.validate(path, profiles[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldLine returns the 1-based line of the value of a field, named as in
+// fieldError, in raw JSON, or 0 if it is not found.
+func fieldLine(data []byte, field string) int {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	offset, ok := findField(decoder, "", field)
+	if !ok {
+		return 0
+	}
+	// The offset is just past the previous token, move on to the value
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// findField walks the JSON value at the decoder's position and returns the
+// input offset of the value at field.
+func findField(decoder *json.Decoder, path, field string) (int64, bool) {
+	if path != "" && path == field {
+		return decoder.InputOffset(), true
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, false
+	}
+	switch token {
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return 0, false
+			}
+			name, _ := key.(string)
+			if offset, ok := findField(decoder, joinPath(path, name), field); ok {
+				return offset, true
+			}
+		}
+		decoder.Token()
+	case json.Delim('['):
+		for i := 0; decoder.More(); i++ {
+			if offset, ok := findField(decoder, fmt.Sprintf("%s[%d]", path, i), field); ok {
+				return offset, true
+			}
+		}
+		decoder.Token()
+	}
+	return 0, false
+}
+
+func (s *schema) validate(path string, value interface{}) error {
+	if len(s.OneOf) > 0 {
+		return s.validateOneOf(path, value)
+	}
+
+	if s.Type != "" && !matchesType(s.Type, value) {
+		return fieldErrorf(path, "expected %s, got %s", s.Type, jsonType(value))
+	}
+
+	if len(s.Enum) > 0 {
+		str, _ := value.(string)
+		for _, allowed := range s.Enum {
+			if str == allowed {
+				return nil
+			}
+		}
+		return fieldErrorf(path, "invalid value %q (allowed: %s)", str, quoteAll(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, v)
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			if *s.MinLength == 1 {
+				return fieldErrorf(path, "must not be empty")
+			}
+			return fieldErrorf(path, "must be at least %d characters long", *s.MinLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid embedded schema: %w", err)
+			}
+			if !re.MatchString(v) {
+				return fieldErrorf(path, "%q does not match %s", v, s.Pattern)
+			}
+		}
+	case json.Number:
+		if s.Minimum != nil {
+			n, _ := v.Float64()
+			if n < *s.Minimum {
+				return fieldErrorf(path, "must be at least %v, got %s", *s.Minimum, v)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *schema) validateObject(path string, obj map[string]interface{}) error {
+	for _, key := range s.Required {
+		if _, ok := obj[key]; !ok {
+			return fieldErrorf(path, "missing required field %q", key)
+		}
+	}
+
+	// Check keys in a stable order so the first error is deterministic
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := joinPath(path, key)
+		if s.PropertyNames != nil {
+			if err := s.PropertyNames.validate(child, key); err != nil {
+				var fe *fieldError
+				if errors.As(err, &fe) {
+					return fieldErrorf(child, "invalid key: %s", fe.msg)
+				}
+				return err
+			}
+		}
+		prop, ok := s.Properties[key]
+		if !ok && s.AdditionalProperties != nil {
+			if !s.AdditionalProperties.allowed {
+				return fieldErrorf(child, "unknown option (allowed: %s)", strings.Join(s.propertyNames(), ", "))
+			}
+			prop, ok = s.AdditionalProperties.schema, s.AdditionalProperties.schema != nil
+		}
+		if !ok {
+			continue
+		}
+		if err := prop.validate(child, obj[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *schema) validateOneOf(path string, value interface{}) error {
+	var types []string
+	for _, alt := range s.OneOf {
+		if alt.Type == "" || matchesType(alt.Type, value) {
+			// Report errors from the alternative of the right type, they are
+			// more specific than a type mismatch
+			return alt.validate(path, value)
+		}
+		types = append(types, alt.Type)
+	}
+	return fieldErrorf(path, "expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+}
+
+func (s *schema) propertyNames() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func matchesType(want string, value interface{}) bool {
+	got := jsonType(value)
+	if want == "number" && got == "integer" {
+		return true
+	}
+	return want == got
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,68 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommentGroupHeadersSchema(t *testing.T) {
+	tests := []struct {
+		name, config, err string
+	}{
+		{"valid", `{"groups": ["App\\", "*"], "comment_group_headers": {"0": "// App", "1": "// Vendor"}}`, ""},
+		{"not an index", `{"groups": ["App\\"], "comment_group_headers": {"app": "// App"}}`, `comment_group_headers.app: invalid key: "app" does not match`},
+		{"negative index", `{"groups": ["App\\"], "comment_group_headers": {"-1": "// App"}}`, `comment_group_headers.-1: invalid key`},
+		{"leading zero", `{"groups": ["App\\"], "comment_group_headers": {"00": "// App"}}`, `comment_group_headers.00: invalid key`},
+		{"not a string", `{"groups": ["App\\"], "comment_group_headers": {"0": 1}}`, `comment_group_headers.0: expected string, got integer`},
+		{"unknown option", `{"grups": ["App\\"]}`, `grups: unknown option (allowed: `},
+		{"no such group", `{"groups": ["App\\"], "comment_group_headers": {"1": "// App"}}`, `comment_group_headers.1: no group at index 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ConfigFileName)
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig: %v", err)
+				}
+				if config.Groups[1].Header != "// Vendor" {
+					t.Errorf("groups[1].header = %q, want %q", config.Groups[1].Header, "// Vendor")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadConfig: err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name, config, err string
+	}{
+		{"invalid enum", `{"sort_by": "alphabetical"}`, `psort.json:1: sort_by: invalid value "alphabetical" (allowed: "alpha", "depth", "length")`},
+		{"wrong type", "{\n  \"newline_between_groups\": \"yes\"\n}", `psort.json:2: newline_between_groups: expected boolean, got string`},
+		{"wrong item type", "{\n  \"groups\": [\n    \"*\",\n    1\n  ]\n}", `psort.json:4: groups[1]: expected string or object, got integer`},
+		{"not an array", `{"groups": "App"}`, `psort.json:1: groups: expected array, got string`},
+		{"below minimum", `{"max_line_width": -1}`, `psort.json:1: max_line_width: must be at least 0, got -1`},
+		{"unknown option", "{\n  \"sort_mode\": \"alpha\"\n}", `psort.json:2: sort_mode: unknown option (allowed: `},
+		{"malformed", `{"groups": ["*",`, `psort.json: unexpected EOF`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ConfigFileName)
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadConfig: err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,973 @@
+// Package sorter sorts the use statements of PHP files. It is the core of
+// the psort command: SortReader and SortFile cover the common cases, Sort and
+// Prepare give control over diagnostics and when files are replaced.
+package sorter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Options holds the settings that affect diagnostics and how files are
+// written, as opposed to how their imports are sorted.
+type Options struct {
+	// SafeWrite re-checks the original file before replacing it and skips the
+	// write if it was modified while being sorted.
+	SafeWrite bool
+	// Debug logs spacing decisions, moved lines and skipped files to stderr.
+	Debug bool
+	// Backup copies each modified file to a backup before replacing it.
+	Backup bool
+	// Converge re-sorts each result until it is stable, warning if it is not.
+	Converge bool
+	// ReportUnused warns about imports whose name does not appear in the
+	// rest of the file.
+	ReportUnused bool
+	// Verify re-sorts each result once and fails with ErrNotIdempotent if
+	// that changes it.
+	Verify bool
+	// Warnings receives warnings, stderr if nil.
+	Warnings io.Writer
+	// Range limits sorting to the use blocks overlapping those lines, the
+	// zero value sorts every block.
+	Range LineRange
+
+	// silent suppresses warnings and verbose logging, for internal re-runs.
+	silent bool
+}
+
+// LineRange is an inclusive span of 1-based line numbers.
+type LineRange struct {
+	Start, End int
+}
+
+// overlaps reports whether the lines start to end intersect the range, which
+// every block does for the zero range.
+func (r LineRange) overlaps(start, end int) bool {
+	return r == LineRange{} || (start <= r.End && end >= r.Start)
+}
+
+// warnf prints a warning unless diagnostics are silenced.
+func (o *Options) warnf(format string, args ...interface{}) {
+	if o.silent {
+		return
+	}
+	w := o.Warnings
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "Warning: "+format+"\n", args...)
+}
+
+// debugf logs to stderr under Debug.
+func (o *Options) debugf(format string, args ...interface{}) {
+	if o.Debug && !o.silent {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// ErrChangedOnDisk is returned when SafeWrite detects a concurrent edit.
+var ErrChangedOnDisk = errors.New("file changed on disk while sorting, write skipped")
+
+// ErrNotIdempotent is returned when Verify finds that sorting the sorted
+// output changes it again.
+var ErrNotIdempotent = errors.New("sorting the result again changes it, write skipped")
+
+// ParseError is returned when a file's imports can't be read, such as a
+// group use or a block comment that is never closed. Line is the 1-based
+// line the offending statement or comment starts on.
+type ParseError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+// Error leaves out Path, which callers already print next to the error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// SortReader sorts PHP source read from r and writes the result to w,
+// reporting whether it differs from the input. Warnings go to stderr.
+func SortReader(r io.Reader, w io.Writer, cfg Config) (bool, error) {
+	if err := compileCopy(&cfg); err != nil {
+		return false, err
+	}
+	original, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	result, err := Sort(original, "<input>", &cfg, &Options{})
+	if err != nil {
+		return false, err
+	}
+	if _, err := w.Write(result.Output); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(original, result.Output), nil
+}
+
+// SortFile sorts the imports of a file in place and reports whether its
+// content changed. The file is replaced atomically, and not touched at all
+// when it is already sorted.
+func SortFile(path string, cfg Config) (bool, error) {
+	if err := compileCopy(&cfg); err != nil {
+		return false, err
+	}
+	staged, err := Prepare(path, &cfg, &Options{})
+	if err != nil {
+		return false, err
+	}
+	defer staged.Discard()
+	return staged.Changed, staged.Commit()
+}
+
+// compileCopy compiles a Config passed by value, first copying its groups,
+// which Compile rewrites, so that the caller's config is left as it was.
+func compileCopy(cfg *Config) error {
+	if !cfg.compiled {
+		cfg.Groups = slices.Clone(cfg.Groups)
+	}
+	return cfg.Compile()
+}
+
+// Sort sorts the use blocks of PHP source, re-sorting until stable under
+// Converge. filePath is only used in messages.
+func Sort(original []byte, filePath string, config *Config, opts *Options) (Result, error) {
+	result, err := sortContent(original, filePath, config, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if result.Moved > 0 {
+		opts.debugf("%s: %d import lines moved", filePath, result.Moved)
+	}
+	if opts.Converge {
+		result.Output = converge(result.Output, filePath, config, opts)
+	}
+	if opts.Verify {
+		if err := verify(result.Output, filePath, config, opts); err != nil {
+			return Result{}, err
+		}
+	}
+	return result, nil
+}
+
+// Result is the outcome of sorting a file's content.
+type Result struct {
+	Output []byte
+	// Blocks and Imports count the use blocks and use statements found
+	Blocks  int
+	Imports int
+	// Moved counts the import lines whose position changed
+	Moved int
+}
+
+// sortContent sorts the use blocks of PHP source.
+func sortContent(original []byte, filePath string, config *Config, opts *Options) (Result, error) {
+	if hasIgnoreDirective(original) {
+		opts.debugf("%s: skipped, psort:ignore", filePath)
+		return Result{Output: original}, nil
+	}
+	if config.generated != nil && hasGeneratedMarker(original, config.generated) {
+		opts.debugf("%s: skipped, generated file", filePath)
+		return Result{Output: original}, nil
+	}
+	// A byte order mark would otherwise be part of the first line
+	hasBOM := bytes.HasPrefix(original, utf8BOM)
+	original = bytes.TrimPrefix(original, utf8BOM)
+
+	// Lines are written straight into the output, which is about the size
+	// of the input
+	writer := bytes.NewBuffer(make([]byte, 0, len(original)))
+	// Converted once, so that each line is a substring rather than a copy
+	content := string(original)
+
+	var useBlock []string
+	// The block's lines as they were read, written back unchanged when the
+	// block is outside opts.Range, and the line numbers it spans
+	var blockRaw []string
+	blockStart, blockEnd := 0, 0
+	// Empty lines and group headers that may belong to a use block
+	var pendingLines []string
+	inUseBlock := false
+	// Only code inside PHP tags is considered, template output is left alone
+	inPHP := false
+	// Namespace declared by the file, for the <same_namespace> group
+	namespace := ""
+
+	// Lines of a use statement spanning several lines, until its `;`
+	var continued []string
+	// Tracks block comments and heredocs across lines, and the brace depth
+	var code codeState
+	// Line of the `/*` that opened the current block comment
+	commentStart := 0
+	// Depth of the current namespace body. Use lines deeper than that are
+	// trait insertions in a class body, not imports.
+	scopeDepth := 0
+
+	lineNo := 0
+	var result Result
+	// For ReportUnused, the imports with their line and every other line
+	var imports []lineImport
+	var body []string
+	// A use line above the namespace declaration is not an import of the
+	// namespace, and sorting it could move it across declare or namespace
+	nsLine := firstNamespaceLine(original)
+	// Set between psort:disable and psort:enable, where use lines are left
+	// as they are
+	disabled := false
+
+	// Without merge_adjacent_blocks, a blank or comment line between two
+	// imports starts a new block
+	splitBlocks := config.MergeAdjacentBlocks != nil && !*config.MergeAdjacentBlocks
+	// Whether the last block flushed was sorted rather than written raw, and
+	// whether any was
+	blockSorted, sortedAny := false, false
+
+	// flushBlock sorts and writes the collected use block
+	flushBlock := func() error {
+		blockSorted = opts.Range.overlaps(blockStart, blockEnd)
+		sortedAny = sortedAny || blockSorted
+		if blockSorted {
+			write := writeSortedBlock
+			if config.PostCommand != "" {
+				write = writePostProcessedBlock
+			}
+			n, err := write(writer, useBlock, config, opts, filePath, namespace)
+			if err != nil {
+				return err
+			}
+			result.Moved += n
+		} else {
+			for _, rawLine := range blockRaw {
+				if err := writeLine(writer, rawLine); err != nil {
+					return err
+				}
+			}
+		}
+		result.Blocks++
+		useBlock, blockRaw = []string{}, nil
+		return nil
+	}
+
+	for line := range strings.Lines(content) {
+		// Like bufio.ScanLines, without the \n or \r\n
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		lineNo++
+		trimmed := strings.TrimSpace(line)
+		isPHP := inPHP
+		inPHP = scanPHPTags(line, inPHP)
+		atFileScope := code.depth == scopeDepth
+		beforeNamespace := lineNo < nsLine
+		// Lines starting inside a comment or heredoc are never code
+		inComment, inLiteral := code.inComment, code.inComment || code.heredoc != ""
+		if isPHP || inPHP {
+			code.scan(line)
+			scopeDepth = min(scopeDepth, code.depth)
+			if code.inComment && !inComment {
+				commentStart = lineNo
+			}
+		}
+		if isPHP != inPHP {
+			// Lines that open or close a PHP region are never imports
+			isPHP = false
+		}
+
+		if len(continued) > 0 {
+			continued = append(continued, line)
+			if !strings.HasSuffix(stripTrailingComment(trimmed), ";") {
+				continue
+			}
+			// Treat the whole declaration as one logical import line
+			line = strings.Join(continued, "\n")
+			trimmed = strings.TrimSpace(line)
+			continued = nil
+			// The statement started at file scope, its own braces don't count
+			isPHP, atFileScope = true, true
+		} else if isPHP && atFileScope && !beforeNamespace && !disabled && !inLiteral && isMultilineUseStart(trimmed) {
+			continued = []string{line}
+			continue
+		}
+		// First line of the statement, earlier for one spanning several
+		stmtStart := lineNo - strings.Count(line, "\n")
+
+		if isPHP && !inLiteral && strings.HasPrefix(trimmed, "namespace ") {
+			namespace = parseNamespace(trimmed)
+			if strings.HasSuffix(trimmed, "{") {
+				scopeDepth = code.depth
+			}
+		}
+		// A directive ends the use block it is in rather than moving with it
+		isDirective := isPHP && (inComment || !inLiteral) && (hasDirective(line, "disable") || hasDirective(line, "enable"))
+		if isDirective {
+			disabled = hasDirective(line, "disable")
+		}
+		isUse := isPHP && atFileScope && !beforeNamespace && !disabled && !inLiteral && strings.HasPrefix(trimmed, "use ") && strings.HasSuffix(stripTrailingComment(trimmed), ";")
+		isEmpty := trimmed == ""
+		if opts.ReportUnused {
+			if isUse {
+				imports = append(imports, lineImport{line: trimmed, lineNo: stmtStart})
+			} else {
+				body = append(body, line)
+			}
+		}
+		isHeader := isPHP && !inLiteral && !disabled && !isDirective && isGroupHeader(trimmed, config.Groups)
+		// Comments between the imports of a block move with the import below
+		isComment := isPHP && inUseBlock && !isDirective && (inComment || (!inLiteral && isCommentLine(trimmed)))
+
+		if isUse {
+			if !inUseBlock {
+				inUseBlock = true
+			} else if splitBlocks && separatesBlocks(pendingLines, config.Groups) {
+				// The lines in between stay where they are, but for group
+				// headers, which the next block writes again
+				if err := flushBlock(); err != nil {
+					return Result{}, err
+				}
+				for _, pendingLine := range pendingLines {
+					if isGroupHeader(strings.TrimSpace(pendingLine), config.Groups) {
+						continue
+					}
+					if err := writeLine(writer, pendingLine); err != nil {
+						return Result{}, err
+					}
+				}
+				pendingLines = []string{}
+			} else if last := lastBlankLine(pendingLines); config.PreserveBlankLines && last >= 0 {
+				// A blank line left by the author separates two blocks that
+				// are sorted on their own
+				if err := flushBlock(); err != nil {
+					return Result{}, err
+				}
+				for _, pendingLine := range pendingLines[:last+1] {
+					if isGroupHeader(strings.TrimSpace(pendingLine), config.Groups) {
+						continue
+					}
+					if err := writeLine(writer, pendingLine); err != nil {
+						return Result{}, err
+					}
+				}
+				pendingLines = pendingLines[last+1:]
+			}
+			if width := config.WarnOnLongImports; width > 0 && !strings.Contains(line, "\n") && utf8.RuneCountInString(line) > width {
+				opts.warnf("%s:%d: import is %d characters long, exceeds %d", filePath, lineNo, utf8.RuneCountInString(line), width)
+			}
+			// Discard empty lines and headers within a use block (consolidate),
+			// headers are re-emitted by writeSortedBlock. Comments are kept
+			// on the lines above the import.
+			var attached []string
+			for _, pendingLine := range pendingLines {
+				if pending := strings.TrimSpace(pendingLine); pending != "" && !isGroupHeader(pending, config.Groups) {
+					attached = append(attached, pendingLine)
+				}
+			}
+			if len(blockRaw) == 0 {
+				blockStart = stmtStart - len(pendingLines)
+			}
+			blockRaw = append(append(blockRaw, pendingLines...), line)
+			blockEnd = lineNo
+			if len(attached) > 0 {
+				line = strings.Join(append(attached, line), "\n")
+			}
+			pendingLines = []string{}
+			useBlock = append(useBlock, line)
+			result.Imports++
+		} else if isHeader || isComment || (isEmpty && (inUseBlock || len(pendingLines) > 0)) {
+			// Buffer until we know whether a use statement follows
+			pendingLines = append(pendingLines, line)
+		} else if isEmpty {
+			// Not in use block, write immediately
+			if err := writeLine(writer, line); err != nil {
+				return Result{}, err
+			}
+		} else {
+			if inUseBlock {
+				// End of use block
+				if err := flushBlock(); err != nil {
+					return Result{}, err
+				}
+				inUseBlock = false
+				if config.BlankLineAfterImports && blockSorted && isPHP && !strings.HasPrefix(trimmed, "}") {
+					// Exactly one blank line, in place of those the author left
+					for len(pendingLines) > 0 && strings.TrimSpace(pendingLines[0]) == "" {
+						pendingLines = pendingLines[1:]
+					}
+					pendingLines = append([]string{""}, pendingLines...)
+				}
+			}
+			// Write any pending lines that came after the last use statement
+			for _, pendingLine := range pendingLines {
+				if err := writeLine(writer, pendingLine); err != nil {
+					return Result{}, err
+				}
+			}
+			pendingLines = []string{}
+			if err := writeLine(writer, line); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	// Flush remaining if file ends with use block
+	if inUseBlock {
+		if err := flushBlock(); err != nil {
+			return Result{}, err
+		}
+	}
+	if len(continued) > 0 {
+		// Sorting around it could move code into or out of the statement
+		return Result{}, &ParseError{Path: filePath, Line: lineNo - len(continued) + 1, Msg: "use statement is never terminated by `;`"}
+	}
+	if code.inComment {
+		// Whether the lines after it are code can't be told
+		return Result{}, &ParseError{Path: filePath, Line: commentStart, Msg: "block comment is never closed by `*/`"}
+	}
+	// Write any pending lines at EOF
+	for _, pendingLine := range pendingLines {
+		if err := writeLine(writer, pendingLine); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if opts.ReportUnused {
+		reportUnused(imports, strings.Join(body, "\n"), filePath, opts)
+	}
+	result.Output = writer.Bytes()
+	// A Range that overlaps no block leaves the file alone, its ending too
+	if config.EnsureFinalNewline && (opts.Range == LineRange{} || sortedAny) {
+		// Exactly one, whether the file had none or ended in blank lines
+		if trimmed := bytes.TrimRight(result.Output, "\n"); len(trimmed) > 0 {
+			if !bytes.HasSuffix(original, []byte("\n")) || len(trimmed) < len(result.Output)-1 {
+				opts.debugf("%s: final newline normalized", filePath)
+			}
+			result.Output = append(trimmed, '\n')
+		}
+	} else if !bytes.HasSuffix(original, []byte("\n")) {
+		// Every line was written with a newline, including a last one that
+		// had none
+		result.Output = bytes.TrimSuffix(result.Output, []byte("\n"))
+	}
+	if usesCRLF(original) {
+		// Lines are written with \n throughout, the scanner having dropped
+		// each line's \r
+		result.Output = bytes.ReplaceAll(result.Output, []byte("\n"), []byte("\r\n"))
+	}
+	if hasBOM {
+		result.Output = append(append([]byte(nil), utf8BOM...), result.Output...)
+	}
+	return result, nil
+}
+
+// writeLine writes a line followed by a newline. Writing them separately
+// leaves the lines passed through unchanged without an allocation each.
+func writeLine(w *bytes.Buffer, line string) error {
+	if _, err := w.WriteString(line); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+// newLineScanner scans content line by line. Content is already in memory, so
+// lines may be as long as the content itself, such as a minified file or a
+// group use written on one line, instead of bufio's default 64KB.
+func newLineScanner(content []byte) *bufio.Scanner {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	return scanner
+}
+
+// writePostProcessedBlock is writeSortedBlock with the sorted block passed
+// through post_command before it is written.
+func writePostProcessedBlock(w *bytes.Buffer, block []string, config *Config, opts *Options, filePath, namespace string) (int, error) {
+	var sorted bytes.Buffer
+	moved, err := writeSortedBlock(&sorted, block, config, opts, filePath, namespace)
+	if err != nil {
+		return 0, err
+	}
+	processed, err := runPostCommand(config.PostCommand, sorted.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.Write(processed)
+	return moved, err
+}
+
+// runPostCommand passes a sorted use block through post_command, run by the
+// shell, and returns its output, ending with a newline like the block. A
+// failing command is an error, so the file is left unchanged.
+func runPostCommand(command string, block []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(block)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("post_command: %v: %s", err, message)
+		}
+		return nil, fmt.Errorf("post_command: %v", err)
+	}
+	// The scanner has dropped the \r of \r\n line endings, they are added
+	// back to the whole file
+	output = bytes.ReplaceAll(output, []byte("\r\n"), []byte("\n"))
+	if len(output) > 0 && !bytes.HasSuffix(output, []byte("\n")) {
+		output = append(output, '\n')
+	}
+	return output, nil
+}
+
+// utf8BOM is the byte order mark some Windows editors put at the start of
+// UTF-8 files.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// ignoreDirectiveLines is how far into a file a psort:ignore comment is
+// looked for.
+const ignoreDirectiveLines = 20
+
+// hasIgnoreDirective reports whether a `psort:ignore` comment appears in the
+// first lines of a file, which is then left untouched.
+func hasIgnoreDirective(content []byte) bool {
+	scanner := newLineScanner(content)
+	for i := 0; i < ignoreDirectiveLines && scanner.Scan(); i++ {
+		if hasDirective(scanner.Text(), "ignore") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasGeneratedMarker reports whether the first lines of a file, as far as a
+// psort:ignore comment is looked for, match the generated_marker pattern.
+func hasGeneratedMarker(content []byte, marker *regexp.Regexp) bool {
+	scanner := newLineScanner(content)
+	for i := 0; i < ignoreDirectiveLines && scanner.Scan(); i++ {
+		if marker.Match(scanner.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDirective reports whether a line carries a directive such as
+// `// psort:disable` in a comment.
+func hasDirective(line, name string) bool {
+	i := strings.Index(line, "psort:"+name)
+	if i < 0 {
+		return false
+	}
+	before := line[:i]
+	return strings.Contains(before, "//") || strings.Contains(before, "#") || strings.Contains(before, "/*") ||
+		strings.HasPrefix(strings.TrimSpace(before), "*")
+}
+
+// firstNamespaceLine returns the 1-based line of the first namespace
+// declaration in PHP code, or 0 if there is none.
+func firstNamespaceLine(original []byte) int {
+	scanner := newLineScanner(original)
+	inPHP := false
+	var code codeState
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		isPHP := inPHP
+		inPHP = scanPHPTags(line, inPHP)
+		inLiteral := code.inComment || code.heredoc != ""
+		if isPHP || inPHP {
+			code.scan(line)
+		}
+		if isPHP && inPHP && !inLiteral && strings.HasPrefix(strings.TrimSpace(line), "namespace ") {
+			return lineNo
+		}
+	}
+	return 0
+}
+
+// lastBlankLine returns the index of the last empty line in lines, or -1.
+func lastBlankLine(lines []string) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// separatesBlocks reports whether the lines between two imports include a
+// blank line or a comment other than a group header.
+func separatesBlocks(lines []string, groups []Group) bool {
+	for _, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed == "" || !isGroupHeader(trimmed, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesCRLF reports whether the first line ending in content is \r\n. Files
+// mixing line endings are rewritten with the first one seen.
+func usesCRLF(content []byte) bool {
+	i := bytes.IndexByte(content, '\n')
+	return i > 0 && content[i-1] == '\r'
+}
+
+// maxConvergePasses bounds how often Converge re-sorts its own output.
+const maxConvergePasses = 3
+
+// converge re-applies the sort to its own output until it no longer changes,
+// warning with the divergent lines if it is still changing after
+// maxConvergePasses. Sorting is expected to be idempotent, so a warning here
+// points at a bug in how options interact.
+func converge(output []byte, filePath string, config *Config, opts *Options) []byte {
+	// Diagnostics were already reported by the first pass
+	rerun := *opts
+	rerun.silent = true
+	for pass := 0; pass < maxConvergePasses; pass++ {
+		result, err := sortContent(output, filePath, config, &rerun)
+		next := result.Output
+		if err != nil || bytes.Equal(next, output) {
+			return output
+		}
+		if pass == maxConvergePasses-1 {
+			opts.warnf("%s: sort did not converge after %d passes", filePath, maxConvergePasses)
+			reportDivergence(output, next, opts)
+		}
+		output = next
+	}
+	return output
+}
+
+// verify sorts output once more and returns ErrNotIdempotent, after warning
+// with the divergent lines, if the second pass changes it.
+func verify(output []byte, filePath string, config *Config, opts *Options) error {
+	rerun := *opts
+	rerun.silent = true
+	result, err := sortContent(output, filePath, config, &rerun)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(result.Output, output) {
+		opts.warnf("%s: second pass differs from the sorted output", filePath)
+		reportDivergence(output, result.Output, opts)
+		return ErrNotIdempotent
+	}
+	return nil
+}
+
+// reportDivergence warns about the first lines differing between two passes.
+func reportDivergence(before, after []byte, opts *Options) {
+	beforeLines := strings.Split(string(before), "\n")
+	afterLines := strings.Split(string(after), "\n")
+	reported := 0
+	for i := 0; i < len(beforeLines) || i < len(afterLines); i++ {
+		var b, a string
+		if i < len(beforeLines) {
+			b = beforeLines[i]
+		}
+		if i < len(afterLines) {
+			a = afterLines[i]
+		}
+		if a == b {
+			continue
+		}
+		opts.warnf("  line %d: `%s` -> `%s`", i+1, b, a)
+		if reported++; reported == 5 {
+			return
+		}
+	}
+}
+
+// StagedFile is a sorted file written to a temp file, waiting to replace the
+// original.
+type StagedFile struct {
+	// Path is the file being sorted
+	Path string
+	// Changed reports whether the sorted content differs from the original
+	Changed bool
+	// Moved counts the import lines whose position changed, see Result
+	Moved int
+
+	tempPath string
+	info     os.FileInfo
+	original []byte
+	// safeWrite and backupSuffix are taken from the options at Prepare
+	safeWrite    bool
+	backupSuffix string
+}
+
+// Prepare sorts a file into a temp file without touching the original, so
+// that several files can be replaced together. The caller must Commit or
+// Discard the result.
+func Prepare(filePath string, config *Config, opts *Options) (*StagedFile, error) {
+	original, info, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	mode := info.Mode()
+
+	// Sort into memory so the result can be compared with the original
+	result, err := Sort(original, filePath, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	output := result.Output
+
+	staged := &StagedFile{
+		Path:         filePath,
+		Changed:      !bytes.Equal(original, output),
+		Moved:        result.Moved,
+		info:         info,
+		original:     original,
+		safeWrite:    opts.SafeWrite,
+		backupSuffix: backupSuffix(config, opts),
+	}
+	if !staged.Changed {
+		// Nothing to write, leaving the file and its mtime alone
+		return staged, nil
+	}
+
+	tempFile, err := createTempBeside(filePath)
+	if err != nil {
+		return nil, err
+	}
+	staged.tempPath = tempFile.Name()
+
+	// Flushed to disk before Commit renames it, so that a crash right after
+	// the rename cannot leave an empty or truncated file in place of the
+	// original
+	_, err = tempFile.Write(output)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		staged.Discard()
+		return nil, err
+	}
+
+	// Preserve permissions
+	if err := os.Chmod(staged.tempPath, mode); err != nil {
+		staged.Discard()
+		return nil, err
+	}
+	return staged, nil
+}
+
+// readFile reads a file along with its info, from the same open file so that
+// the two match.
+func readFile(path string) ([]byte, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, info, nil
+}
+
+// createTempBeside creates the temp file for a sorted file in the same
+// directory, so that replacing the original is a rename within one
+// filesystem, which is atomic, rather than a failing cross-device link. The
+// name is hidden and does not end in .php so that walks skip it.
+func createTempBeside(path string) (*os.File, error) {
+	return createTemp(filepath.Dir(path), "."+filepath.Base(path)+".psort-*.tmp")
+}
+
+// createTemp and renameFile are the file operations of a write, replaced by
+// tests to inject faults.
+var (
+	createTemp = os.CreateTemp
+	renameFile = os.Rename
+)
+
+// Commit replaces the original file with the sorted temp file. A file that
+// is already sorted is not touched. If Commit fails, the original is left as
+// it was and the temp file is removed.
+func (s *StagedFile) Commit() error {
+	if !s.Changed {
+		return nil
+	}
+	defer s.Discard()
+
+	// Guard against clobbering an edit made by another process since we read the file
+	if s.safeWrite {
+		if err := s.CheckUnchanged(); err != nil {
+			return err
+		}
+	}
+
+	// Keep the original around as an undo path
+	if s.backupSuffix != "" {
+		if err := os.WriteFile(s.Path+s.backupSuffix, s.original, s.info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	// Replace original file
+	if err := renameFile(s.tempPath, s.Path); err != nil {
+		return err
+	}
+	s.tempPath = ""
+	return nil
+}
+
+// CheckUnchanged returns ErrChangedOnDisk if the original file's modification
+// time or size differ from when it was read.
+func (s *StagedFile) CheckUnchanged() error {
+	current, err := os.Stat(s.Path)
+	if err != nil {
+		return err
+	}
+	if !current.ModTime().Equal(s.info.ModTime()) || current.Size() != s.info.Size() {
+		return ErrChangedOnDisk
+	}
+	return nil
+}
+
+// Discard removes the temp file if it has not been committed.
+func (s *StagedFile) Discard() {
+	if s.tempPath == "" {
+		return
+	}
+	os.Remove(s.tempPath)
+	s.tempPath = ""
+}
+
+// backupSuffix returns the suffix for backups of modified files, or "" when
+// backups are disabled. Backup defaults the suffix to ".bak".
+func backupSuffix(config *Config, opts *Options) string {
+	if config.BackupSuffix != "" {
+		return config.BackupSuffix
+	}
+	if opts.Backup {
+		return ".bak"
+	}
+	return ""
+}
+
+// isMultilineUseStart reports whether a trimmed line opens a use statement
+// that continues on the following lines, such as `use App\Models\{`. A trait
+// use with a conflict resolution block (`use A, B {`) is not one.
+func isMultilineUseStart(trimmed string) bool {
+	if !strings.HasPrefix(trimmed, "use ") || strings.Contains(trimmed, ";") {
+		return false
+	}
+	return !strings.HasSuffix(trimmed, "{") || strings.HasSuffix(trimmed, "\\{")
+}
+
+// codeState is the state of PHP code carried from one line to the next.
+type codeState struct {
+	// depth is the brace depth outside comments and string literals
+	depth int
+	// inComment is set inside a `/* */` comment
+	inComment bool
+	// heredoc is the closing identifier inside a heredoc or nowdoc
+	heredoc string
+}
+
+// scan advances the state over a line of PHP code, tracking braces while
+// skipping string literals, comments (but not `#[` attributes) and heredocs.
+// Strings spanning several lines are not tracked.
+func (st *codeState) scan(line string) {
+	i := 0
+	if st.heredoc != "" {
+		// Since PHP 7.3 the closing identifier may be indented and followed
+		// by more code on the same line
+		rest := strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(rest, st.heredoc) || (len(rest) > len(st.heredoc) && isIdentByte(rest[len(st.heredoc)])) {
+			return
+		}
+		i = len(line) - len(rest) + len(st.heredoc)
+		st.heredoc = ""
+	}
+
+	var quote byte
+	for ; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case st.inComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				st.inComment = false
+				i++
+			}
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(line[i:], "/*"):
+			st.inComment = true
+			i++
+		case (c == '#' && !strings.HasPrefix(line[i:], "#[")) || strings.HasPrefix(line[i:], "//"):
+			return
+		case strings.HasPrefix(line[i:], "<<<"):
+			id := strings.Trim(strings.TrimSpace(line[i+3:]), `'"`)
+			end := 0
+			for end < len(id) && isIdentByte(id[end]) {
+				end++
+			}
+			if end > 0 {
+				st.heredoc = id[:end]
+				return
+			}
+			i += 2
+		case c == '{':
+			st.depth++
+		case c == '}':
+			st.depth = max(st.depth-1, 0)
+		}
+	}
+}
+
+// isIdentByte reports whether c can be part of a PHP identifier.
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// scanPHPTags returns whether the scanner is inside a PHP region at the end of
+// line, given the state at its start. `<?php`, `<?=` and short `<?` open a
+// region and `?>` closes it. An `<?xml` declaration is not an open tag.
+func scanPHPTags(line string, inPHP bool) bool {
+	for {
+		if inPHP {
+			i := strings.Index(line, "?>")
+			if i < 0 {
+				return true
+			}
+			line = line[i+2:]
+			inPHP = false
+			continue
+		}
+		i := strings.Index(line, "<?")
+		if i < 0 {
+			return false
+		}
+		line = line[i+2:]
+		if strings.HasPrefix(line, "xml") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "php")
+		inPHP = true
+	}
+}
@@ -0,0 +1,1081 @@
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// loadTestConfig writes a config to a temporary psort.json and loads it, so
+// that it goes through the same validation as a real one.
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return config
+}
+
+// sortSource sorts PHP source with the given config and options, failing
+// the test on an error. Warnings are discarded unless opts sets a writer.
+func sortSource(t *testing.T, config *Config, opts *Options, src string) string {
+	t.Helper()
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Warnings == nil {
+		opts.Warnings = io.Discard
+	}
+	result, err := Sort([]byte(src), "test.php", config, opts)
+	if err != nil {
+		t.Fatalf("Sort: %v", err)
+	}
+	return string(result.Output)
+}
+
+func TestLineEndings(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"lf", "<?php\nuse B;\nuse A;\n", "<?php\nuse A;\nuse B;\n"},
+		{"crlf", "<?php\r\nuse B;\r\nuse A;\r\n", "<?php\r\nuse A;\r\nuse B;\r\n"},
+		{"no final newline", "<?php\nuse B;\nuse A;", "<?php\nuse A;\nuse B;"},
+		{"crlf without final newline", "<?php\r\nuse B;\r\nuse A;", "<?php\r\nuse A;\r\nuse B;"},
+		{"crlf code kept", "<?php\r\nuse B;\r\nuse A;\r\n\r\nclass X {}\r\n", "<?php\r\nuse A;\r\nuse B;\r\n\r\nclass X {}\r\n"},
+		{"mixed, crlf first", "<?php\r\nuse B;\nuse A;\r\n\nclass X {}\n", "<?php\r\nuse A;\r\nuse B;\r\n\r\nclass X {}\r\n"},
+		{"mixed, lf first", "<?php\nuse B;\r\nuse A;\r\n", "<?php\nuse A;\nuse B;\n"},
+		{"bom", "\xef\xbb\xbf<?php\nuse B;\nuse A;\n", "\xef\xbb\xbf<?php\nuse A;\nuse B;\n"},
+		{"blank lines kept", "<?php\n\n\nuse B;\nuse A;\n\n\nclass X {}\n", "<?php\n\n\nuse A;\nuse B;\n\n\nclass X {}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, &Config{}, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkProcessLargeFile sorts a file of about 10MB that is nearly all
+// code after its use block, which measures the lines passed through.
+func BenchmarkProcessLargeFile(b *testing.B) {
+	var src strings.Builder
+	src.WriteString("<?php\nnamespace App;\n\nuse Foo\\Z;\nuse Foo\\A;\n\nclass X {\n")
+	for range 200000 {
+		src.WriteString("    public function f() { return $this->value + 1; }\n")
+	}
+	src.WriteString("}\n")
+	content := []byte(src.String())
+	config := &Config{}
+
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Sort(content, "large.php", config, &Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSortReader(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+		changed         bool
+	}{
+		{"unsorted", "<?php\nuse B;\nuse A;\n", "<?php\nuse A;\nuse B;\n", true},
+		{"sorted", "<?php\nuse A;\nuse B;\n", "<?php\nuse A;\nuse B;\n", false},
+		{"no imports", "<?php\necho 1;\n", "<?php\necho 1;\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			changed, err := SortReader(strings.NewReader(tt.src), &out, Config{})
+			if err != nil {
+				t.Fatalf("SortReader: %v", err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSortReaderParseError(t *testing.T) {
+	var out strings.Builder
+	_, err := SortReader(strings.NewReader("<?php\nuse App\\{\n    A,\n"), &out, Config{})
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err = %v, want a *ParseError", err)
+	}
+	if pe.Line != 2 {
+		t.Errorf("Line = %d, want 2", pe.Line)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %q on a parse error", out.String())
+	}
+}
+
+func TestSortReaderCompilesConfig(t *testing.T) {
+	config := Config{Groups: []Group{{Match: "re:^Z"}, {Prefix: "*"}}}
+	var out strings.Builder
+	if _, err := SortReader(strings.NewReader("<?php\nuse Alpha;\nuse Zed;\n"), &out, config); err != nil {
+		t.Fatalf("SortReader: %v", err)
+	}
+	if want := "<?php\nuse Zed;\nuse Alpha;\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if config.Groups[0].Match != "re:^Z" || config.Groups[0].re != nil {
+		t.Errorf("caller's config was changed: %+v", config.Groups[0])
+	}
+
+	config = Config{Groups: []Group{{Prefix: "re:("}}}
+	if _, err := SortReader(strings.NewReader("<?php\n"), &out, config); err == nil {
+		t.Error("invalid pattern: err = nil")
+	}
+}
+
+func TestSortFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(path, []byte("<?php\nuse B;\nuse A;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := SortFile(path, Config{})
+	if err != nil {
+		t.Fatalf("SortFile: %v", err)
+	}
+	if !changed {
+		t.Error("changed = false on an unsorted file")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<?php\nuse A;\nuse B;\n"; string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err = SortFile(path, Config{})
+	if err != nil {
+		t.Fatalf("SortFile: %v", err)
+	}
+	if changed {
+		t.Error("changed = true on a sorted file")
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(info, after) {
+		t.Error("sorted file was replaced")
+	}
+}
+
+func TestSortFileMissing(t *testing.T) {
+	_, err := SortFile(filepath.Join(t.TempDir(), "missing.php"), Config{})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestSafeWriteDetectsConcurrentEdit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.php")
+	if err := os.WriteFile(path, []byte("<?php\nuse B;\nuse A;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := Prepare(path, &Config{}, &Options{SafeWrite: true, Warnings: io.Discard})
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	defer staged.Discard()
+
+	// Another process edits the file between the read and the rename
+	edited := "<?php\nuse B;\nuse A;\nuse C;\n"
+	if err := os.WriteFile(path, []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.Commit(); !errors.Is(err, ErrChangedOnDisk) {
+		t.Fatalf("Commit: err = %v, want ErrChangedOnDisk", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != edited {
+		t.Errorf("file = %q, want the concurrent edit %q", got, edited)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("left %d files behind, want only test.php", len(entries)-1)
+	}
+}
+
+func TestSafeWriteUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(path, []byte("<?php\nuse B;\nuse A;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := Prepare(path, &Config{}, &Options{SafeWrite: true, Warnings: io.Discard})
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<?php\nuse A;\nuse B;\n"; string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+const unsortedSource = "<?php\nuse B;\nuse A;\n"
+
+// writeUnsorted writes an unsorted file to a fresh directory and returns
+// its path.
+func writeUnsorted(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(path, []byte(unsortedSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// assertUntouched fails the test unless the file at path still holds the
+// unsorted source and is alone in its directory, with no temp file left.
+func assertUntouched(t *testing.T, path string, extra ...string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != unsortedSource {
+		t.Errorf("original = %q, want it unchanged", got)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != filepath.Base(path) && !containsString(extra, name) {
+			t.Errorf("left %s behind", name)
+		}
+	}
+}
+
+func TestSortFileTempWriteFails(t *testing.T) {
+	path := writeUnsorted(t)
+	// A temp file opened read-only, so that writing the sorted content fails
+	t.Cleanup(func() { createTemp = os.CreateTemp })
+	createTemp = func(dir, pattern string) (*os.File, error) {
+		file, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		return os.Open(file.Name())
+	}
+	if _, err := SortFile(path, Config{}); err == nil {
+		t.Fatal("SortFile: err = nil on a failing write")
+	}
+	assertUntouched(t, path)
+}
+
+func TestSortFileRenameFails(t *testing.T) {
+	path := writeUnsorted(t)
+	injected := errors.New("injected rename failure")
+	t.Cleanup(func() { renameFile = os.Rename })
+	renameFile = func(string, string) error { return injected }
+	if _, err := SortFile(path, Config{}); !errors.Is(err, injected) {
+		t.Fatalf("SortFile: err = %v, want the injected failure", err)
+	}
+	assertUntouched(t, path)
+}
+
+func TestSortFileBackupFails(t *testing.T) {
+	path := writeUnsorted(t)
+	// A directory in the way of the backup
+	if err := os.Mkdir(path+".bak", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := Prepare(path, &Config{}, &Options{Backup: true, Warnings: io.Discard})
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if err := staged.Commit(); err == nil {
+		t.Fatal("Commit: err = nil with the backup path taken")
+	}
+	assertUntouched(t, path, "test.php.bak")
+}
+
+func TestSortReaderReadFails(t *testing.T) {
+	// The input breaks off partway through with an error
+	r := io.MultiReader(strings.NewReader("<?php\nuse B;\n"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	var out strings.Builder
+	if _, err := SortReader(r, &out, Config{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("SortReader: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %q after a read error", out.String())
+	}
+}
+
+func TestPostCommand(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		// Only the use block goes through the command
+		{"block only", "<?php\nuse B;\nuse A;\n\nclass x {}\n", "<?php\nUSE A;\nUSE B;\n\nclass x {}\n"},
+		{"every block", "<?php\nuse B;\nuse A;\nfoo();\nuse D;\nuse C;\n", "<?php\nUSE A;\nUSE B;\nfoo();\nUSE C;\nUSE D;\n"},
+		{"crlf restored", "<?php\r\nuse B;\r\nuse A;\r\n\r\nclass x {}\r\n", "<?php\r\nUSE A;\r\nUSE B;\r\n\r\nclass x {}\r\n"},
+	}
+	config := loadTestConfig(t, `{"post_command": "tr a-z A-Z"}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, config, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("failing", func(t *testing.T) {
+		path := writeUnsorted(t)
+		failing := loadTestConfig(t, `{"post_command": "echo broken >&2; exit 3"}`)
+		_, err := SortFile(path, *failing)
+		if err == nil || !strings.Contains(err.Error(), "exit status 3: broken") {
+			t.Fatalf("SortFile: err = %v, want the command's status and stderr", err)
+		}
+		assertUntouched(t, path)
+	})
+}
+
+func TestEnsureFinalNewlineWithRange(t *testing.T) {
+	config := loadTestConfig(t, `{"ensure_final_newline": true}`)
+	// Lines 2-3 are the use block
+	src := "<?php\nuse B;\nuse A;\n\necho 1;"
+	tests := []struct {
+		name string
+		r    LineRange
+		want string
+	}{
+		{"no range", LineRange{}, "<?php\nuse A;\nuse B;\n\necho 1;\n"},
+		{"overlapping", LineRange{Start: 3, End: 3}, "<?php\nuse A;\nuse B;\n\necho 1;\n"},
+		{"outside every block", LineRange{Start: 5, End: 5}, src},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, config, &Options{Range: tt.r}, src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeAdjacentBlocks(t *testing.T) {
+	src := "<?php\nuse D;\nuse C;\n\nuse B;\n// Helpers\nuse Z\\A;\nuse Y;\n\nclass Foo {}\n"
+	tests := []struct {
+		name, config, src, want string
+	}{
+		{"merged by default", `{}`, src, "<?php\nuse B;\nuse C;\nuse D;\nuse Y;\n// Helpers\nuse Z\\A;\n\nclass Foo {}\n"},
+		{"merged", `{"merge_adjacent_blocks": true}`, src, "<?php\nuse B;\nuse C;\nuse D;\nuse Y;\n// Helpers\nuse Z\\A;\n\nclass Foo {}\n"},
+		{"separate", `{"merge_adjacent_blocks": false}`, src, "<?php\nuse C;\nuse D;\n\nuse B;\n// Helpers\nuse Y;\nuse Z\\A;\n\nclass Foo {}\n"},
+		{"code ends a block", `{}`, "<?php\nuse B;\nfoo();\nuse A;\n", "<?php\nuse B;\nfoo();\nuse A;\n"},
+		{"headers don't separate", `{"merge_adjacent_blocks": false, "groups": [{"prefix": "Z\\", "header": "// Z"}, "*"], "newline_between_groups": true}`,
+			"<?php\n// Z\nuse Z\\B;\nuse A;\n// Z\nuse Z\\A;\n", "<?php\n// Z\nuse Z\\A;\nuse Z\\B;\n\nuse A;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, tt.src); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFixtures sorts testdata/<name>/input.php with the psort.json beside it
+// and compares the result with want.php.
+func TestFixtures(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*", "input.php"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	for _, input := range inputs {
+		dir := filepath.Dir(input)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			config, err := LoadConfig(filepath.Join(dir, ConfigFileName))
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(filepath.Join(dir, "want.php"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortSource(t, config, nil, string(src)); got != string(want) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+			// Sorted output is left as it is
+			if got := sortSource(t, config, nil, string(want)); got != string(want) {
+				t.Errorf("want.php is not stable, sorting it gives\n%s", got)
+			}
+		})
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	tests := []struct {
+		name, src string
+		line      int
+	}{
+		{"after the imports", "<?php\nuse B;\nuse A;\n/* Started\n * but never closed\n", 4},
+		{"among the imports", "<?php\nuse B;\n/* Started\nuse A;\n", 3},
+		{"after a closed one", "<?php\n/* Closed */\nuse A;\n$x = 1; /* Open\n", 4},
+		{"reopened on the closing line", "<?php\n/* One\n*/ $x = 1;\n/* Two\n", 4},
+		{"closed", "<?php\n/*\n * Closed\n */\nuse A;\n", 0},
+		{"in a string", "<?php\n$glob = 'src/*';\nuse A;\n", 0},
+		{"outside PHP", "<p>/* not code</p>\n<?php\nuse A;\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Sort([]byte(tt.src), "test.php", &Config{}, &Options{Warnings: io.Discard})
+			if tt.line == 0 {
+				if err != nil {
+					t.Fatalf("Sort: %v", err)
+				}
+				return
+			}
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("err = %v, want a *ParseError", err)
+			}
+			if pe.Line != tt.line || pe.Path != "test.php" {
+				t.Errorf("ParseError at %s:%d, want test.php:%d", pe.Path, pe.Line, tt.line)
+			}
+		})
+	}
+}
+
+func TestReportSameNamespace(t *testing.T) {
+	src := "<?php\nnamespace App\\Http;\n\nuse App\\Http\\Request;\nuse App\\Http\\Kernel as BaseKernel;\nuse App\\Http\\Middleware\\Auth;\nuse function App\\Http\\helper;\nuse App\\Models\\User;\n"
+	var warnings strings.Builder
+	sortSource(t, loadTestConfig(t, `{"report_same_namespace": true}`), &Options{Warnings: &warnings}, src)
+	// Aliases, functions and subnamespaces are not reported
+	if want := "Warning: test.php: App\\Http\\Request is in the file's own namespace App\\Http\n"; warnings.String() != want {
+		t.Errorf("warnings = %q, want %q", warnings.String(), want)
+	}
+}
+
+func TestWarnOnLongImports(t *testing.T) {
+	src := "<?php\nuse App\\Short;\nuse App\\Very\\Long\\Name;\nuse App\\Long as Aliased;\n"
+	var warnings strings.Builder
+	sortSource(t, loadTestConfig(t, `{"warn_on_long_imports": 20}`), &Options{Warnings: &warnings}, src)
+	// The alias counts towards the width
+	want := "Warning: test.php:3: import is 23 characters long, exceeds 20\n" +
+		"Warning: test.php:4: import is 24 characters long, exceeds 20\n"
+	if warnings.String() != want {
+		t.Errorf("warnings = %q, want %q", warnings.String(), want)
+	}
+}
+
+func TestReportUnused(t *testing.T) {
+	src := "<?php\nuse App\\Support\\Used;\nuse App\\Support\\Helper;\nuse function App\\format;\nuse App\\Models\\{Post, User as Member};\n\nclass Foo extends Used\n{\n    public function x(Member $m) { return FORMAT($m); }\n}\n"
+	var warnings strings.Builder
+	sortSource(t, &Config{}, &Options{ReportUnused: true, Warnings: &warnings}, src)
+	// Function names match in any case; an alias hides the name it replaces
+	want := "Warning: test.php:3: class Helper appears to be unused\n" +
+		"Warning: test.php:5: class Post appears to be unused\n"
+	if warnings.String() != want {
+		t.Errorf("warnings = %q, want %q", warnings.String(), want)
+	}
+	warnings.Reset()
+	sortSource(t, &Config{}, &Options{Warnings: &warnings}, src)
+	if warnings.Len() != 0 {
+		t.Errorf("warned without ReportUnused: %q", warnings.String())
+	}
+}
+
+func TestMultiLineUse(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			"wrapped group use",
+			"<?php\nuse Zed;\nuse App\\Models\\{\n    User,\n    Post\n};\nuse Alpha;\nuse App\\Http\\Request;\n",
+			"<?php\nuse Alpha;\nuse App\\Http\\Request;\nuse App\\Models\\{\n    Post,\n    User\n};\nuse Zed;\n",
+		},
+		{
+			"wrapped alias",
+			"<?php\nuse App\\Very\\Long\\Name\n    as Short;\nuse App\\Alpha;\n",
+			"<?php\nuse App\\Alpha;\nuse App\\Very\\Long\\Name\n    as Short;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, &Config{}, nil, tt.src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrailingComments(t *testing.T) {
+	// Ordered by class path, each comment staying on its import
+	src := "<?php\nuse App\\Legacy\\Thing; // TODO remove in v3\nuse App\\Beta;\nuse App\\Alpha; /* keep */\nuse App\\Legacy; # hash\nuse App\\Gamma;\n"
+	want := "<?php\nuse App\\Alpha; /* keep */\nuse App\\Beta;\nuse App\\Gamma;\nuse App\\Legacy; # hash\nuse App\\Legacy\\Thing; // TODO remove in v3\n"
+	if got := sortSource(t, &Config{}, nil, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImportTypes(t *testing.T) {
+	const src = "<?php\nuse const App\\MAX;\nuse function App\\helper;\nuse App\\Zed;\nuse function App\\alpha;\nuse App\\Alpha;\nuse const App\\MIN;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{"separate by default", `{}`, "<?php\nuse App\\Alpha;\nuse App\\Zed;\nuse function App\\alpha;\nuse function App\\helper;\nuse const App\\MAX;\nuse const App\\MIN;\n"},
+		{"interleave", `{"import_types": "interleave"}`, "<?php\nuse App\\Alpha;\nuse const App\\MAX;\nuse const App\\MIN;\nuse App\\Zed;\nuse function App\\alpha;\nuse function App\\helper;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	const src = "<?php\nuse app\\Foo;\nuse App\\Bar;\nuse Vendor\\zeta;\nuse Vendor\\Alpha;\nuse APP\\Baz;\nuse App\\Foo;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{"default", `{}`, "<?php\nuse APP\\Baz;\nuse App\\Bar;\nuse App\\Foo;\nuse Vendor\\Alpha;\nuse Vendor\\zeta;\nuse app\\Foo;\n"},
+		// Names differing only in case keep a case-sensitive order
+		{"folded", `{"case_sensitive": false}`, "<?php\nuse App\\Bar;\nuse APP\\Baz;\nuse App\\Foo;\nuse app\\Foo;\nuse Vendor\\Alpha;\nuse Vendor\\zeta;\n"},
+		// Group matching still respects case, so app\Foo is not in App\
+		{"folded within groups", `{"case_sensitive": false, "groups": ["App\\", "*"]}`, "<?php\nuse App\\Bar;\nuse App\\Foo;\nuse APP\\Baz;\nuse app\\Foo;\nuse Vendor\\Alpha;\nuse Vendor\\zeta;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortFileKeepsCRLF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(path, []byte("<?php\r\nuse B;\r\nuse A;\r\n\r\necho 1;\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SortFile(path, Config{}); err != nil {
+		t.Fatalf("SortFile: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<?php\r\nuse A;\r\nuse B;\r\n\r\necho 1;\r\n"; string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestBlankLineAfterImports(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"none", "<?php\nuse B;\nuse A;\nclass X {}\n", "<?php\nuse A;\nuse B;\n\nclass X {}\n"},
+		{"one", "<?php\nuse B;\nuse A;\n\nclass X {}\n", "<?php\nuse A;\nuse B;\n\nclass X {}\n"},
+		{"several", "<?php\nuse B;\nuse A;\n\n\n\nclass X {}\n", "<?php\nuse A;\nuse B;\n\nclass X {}\n"},
+		{"crlf", "<?php\r\nuse B;\r\nuse A;\r\nclass X {}\r\n", "<?php\r\nuse A;\r\nuse B;\r\n\r\nclass X {}\r\n"},
+		{"comment counts as code", "<?php\nuse B;\nuse A;\n// note\nclass X {}\n", "<?php\nuse A;\nuse B;\n\n// note\nclass X {}\n"},
+		{"closing brace", "<?php\nnamespace App {\nuse B;\nuse A;\n}\n", "<?php\nnamespace App {\nuse A;\nuse B;\n}\n"},
+		{"closing tag", "<?php\nuse B;\nuse A;\n?>\n", "<?php\nuse A;\nuse B;\n?>\n"},
+		{"end of file", "<?php\nuse B;\nuse A;\n", "<?php\nuse A;\nuse B;\n"},
+	}
+	enforce := loadTestConfig(t, `{"blank_line_after_imports": true}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, enforce, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// By default the blank lines are kept as they are
+	for _, src := range []string{"<?php\nuse A;\nclass X {}\n", "<?php\nuse A;\n\n\nclass X {}\n"} {
+		if got := sortSource(t, &Config{}, nil, src); got != src {
+			t.Errorf("default: got %q, want %q", got, src)
+		}
+	}
+}
+
+func TestSortFileKeepsBOM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(path, []byte("\xef\xbb\xbf<?php\r\n\r\nuse B;\r\nuse A;\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SortFile(path, Config{}); err != nil {
+		t.Fatalf("SortFile: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\xef\xbb\xbf<?php\r\n\r\nuse A;\r\nuse B;\r\n"; string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"code with newline", "<?php\nuse B;\nuse A;\n\necho 1;\n", "<?php\nuse A;\nuse B;\n\necho 1;\n"},
+		{"code without newline", "<?php\nuse B;\nuse A;\n\necho 1;", "<?php\nuse A;\nuse B;\n\necho 1;"},
+		// The missing newline belongs to the file, not to the last import
+		{"import without newline moved", "<?php\nuse A;\nuse C;\nuse B;", "<?php\nuse A;\nuse B;\nuse C;"},
+		{"trailing blank lines", "<?php\nuse B;\nuse A;\n\n\n", "<?php\nuse A;\nuse B;\n\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, &Config{}, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	ensure := loadTestConfig(t, `{"ensure_final_newline": true}`)
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"ensured", "<?php\nuse B;\nuse A;", "<?php\nuse A;\nuse B;\n"},
+		{"ensured crlf", "<?php\r\nuse B;\r\nuse A;\r\n\r\necho 1;", "<?php\r\nuse A;\r\nuse B;\r\n\r\necho 1;\r\n"},
+		{"ensured crlf already there", "<?php\r\nuse B;\r\nuse A;\r\n", "<?php\r\nuse A;\r\nuse B;\r\n"},
+		{"ensured crlf trailing blank lines", "<?php\r\nuse B;\r\nuse A;\r\n\r\n\r\n", "<?php\r\nuse A;\r\nuse B;\r\n"},
+		{"ensured with bom", "\xef\xbb\xbf<?php\r\nuse B;\r\nuse A;", "\xef\xbb\xbf<?php\r\nuse A;\r\nuse B;\r\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, ensure, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachedComments(t *testing.T) {
+	// Comments between imports travel with the import below them, those
+	// above the first import stay in place
+	src := "<?php\n/**\n * License header\n */\n\nnamespace App;\n\n/** Imports */\nuse Zed;\n// group: vendor\nuse Beta\\Thing;\nuse Alpha;\n/* multi\n   line */\nuse Aardvark;\n\nclass X {}\n"
+	want := "<?php\n/**\n * License header\n */\n\nnamespace App;\n\n/** Imports */\n/* multi\n   line */\nuse Aardvark;\nuse Alpha;\n// group: vendor\nuse Beta\\Thing;\nuse Zed;\n\nclass X {}\n"
+	if got := sortSource(t, &Config{}, nil, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPreserveBlankLines(t *testing.T) {
+	const src = "<?php\nuse Zed;\nuse App\\B;\n\nuse Beta;\nuse App\\A;\n\n\nuse Alpha;\n"
+	const groups = `"groups": ["App\\", "*"], "newline_between_groups": true`
+	tests := []struct {
+		name, config, want string
+	}{
+		{"dropped by default", `{}`, "<?php\nuse Alpha;\nuse App\\A;\nuse App\\B;\nuse Beta;\nuse Zed;\n"},
+		{"kept", `{"preserve_blank_lines": true}`, "<?php\nuse App\\B;\nuse Zed;\n\nuse App\\A;\nuse Beta;\n\n\nuse Alpha;\n"},
+		{"dropped with group separators", `{` + groups + `}`, "<?php\nuse App\\A;\nuse App\\B;\n\nuse Alpha;\nuse Beta;\nuse Zed;\n"},
+		{"kept with group separators", `{` + groups + `, "preserve_blank_lines": true}`, "<?php\nuse App\\B;\n\nuse Zed;\n\nuse App\\A;\n\nuse Beta;\n\n\nuse Alpha;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	const src = "<?php\nuse App\\Http\\Request;\nuse Zed;\nuse App\\Kernel;\nuse App\\Http\\Controllers\\Controller;\nuse Abc;\nuse App\\Xy;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{"alpha", `{"sort_by": "alpha"}`, "<?php\nuse Abc;\nuse App\\Http\\Controllers\\Controller;\nuse App\\Http\\Request;\nuse App\\Kernel;\nuse App\\Xy;\nuse Zed;\n"},
+		{"depth", `{"sort_by": "depth"}`, "<?php\nuse Abc;\nuse Zed;\nuse App\\Kernel;\nuse App\\Xy;\nuse App\\Http\\Request;\nuse App\\Http\\Controllers\\Controller;\n"},
+		{"length", `{"sort_by": "length"}`, "<?php\nuse Abc;\nuse Zed;\nuse App\\Xy;\nuse App\\Kernel;\nuse App\\Http\\Request;\nuse App\\Http\\Controllers\\Controller;\n"},
+		{"groups first", `{"sort_by": "depth", "groups": ["App\\", "*"]}`, "<?php\nuse App\\Kernel;\nuse App\\Xy;\nuse App\\Http\\Request;\nuse App\\Http\\Controllers\\Controller;\nuse Abc;\nuse Zed;\n"},
+		{
+			"per-group order",
+			`{"groups": [{"prefix": "App\\", "order": "desc"}, {"prefix": "*", "order": "asc"}]}`,
+			"<?php\nuse App\\Xy;\nuse App\\Kernel;\nuse App\\Http\\Request;\nuse App\\Http\\Controllers\\Controller;\nuse Abc;\nuse Zed;\n",
+		},
+		{
+			"per-group sort_by",
+			`{"sort_by": "alpha", "groups": [{"prefix": "App\\", "sort_by": "length"}, {"prefix": "*", "order": "desc"}]}`,
+			"<?php\nuse App\\Xy;\nuse App\\Kernel;\nuse App\\Http\\Request;\nuse App\\Http\\Controllers\\Controller;\nuse Zed;\nuse Abc;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByAlias(t *testing.T) {
+	// Aliases (with `as` in any case) and last segments are compared, group
+	// uses by their full path
+	src := "<?php\nuse App\\Very\\Long\\Name as Short;\nuse App\\Models\\Zebra;\nuse App\\Old AS Alpha;\nuse App\\Models\\{Post, User};\nuse Vendor\\Middle;\nuse App\\Overlaps\\Ask;\n"
+	want := "<?php\nuse App\\Old AS Alpha;\nuse App\\Models\\{Post, User};\nuse App\\Overlaps\\Ask;\nuse Vendor\\Middle;\nuse App\\Very\\Long\\Name as Short;\nuse App\\Models\\Zebra;\n"
+	if got := sortSource(t, loadTestConfig(t, `{"sort_by_alias": true}`), nil, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestComposerGroup(t *testing.T) {
+	const src = "<?php\nuse Vendor\\Lib;\nuse Tests\\Unit\\FooTest;\nuse App\\Models\\User;\nuse Illuminate\\Support\\Str;\n"
+	const composer = `{
+  "autoload": {"psr-4": {"App\\": "app/"}},
+  "autoload-dev": {"psr-4": {"Tests\\": "tests/"}}
+}`
+	tests := []struct {
+		name     string
+		composer string
+		want     string
+	}{
+		{"psr-4 prefixes", composer, "<?php\nuse App\\Models\\User;\nuse Tests\\Unit\\FooTest;\n\nuse Illuminate\\Support\\Str;\nuse Vendor\\Lib;\n"},
+		{"no composer.json", "", "<?php\nuse App\\Models\\User;\nuse Illuminate\\Support\\Str;\nuse Tests\\Unit\\FooTest;\nuse Vendor\\Lib;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.composer != "" {
+				// Looked up from the config's directory upwards
+				if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(tt.composer), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(dir, "tools", ConfigFileName)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(`{"groups": ["<composer>", "*"], "newline_between_groups": true}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := sortSource(t, config, nil, src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclareAndNamespaceBoundaries(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			// In a file with a namespace, use lines above it are not imports
+			"use before namespace",
+			"<?php\nuse Zed\\Early;\nuse Alpha\\Early;\ndeclare(strict_types=1);\n\nnamespace App;\n\nuse Zed;\nuse Alpha;\n",
+			"<?php\nuse Zed\\Early;\nuse Alpha\\Early;\ndeclare(strict_types=1);\n\nnamespace App;\n\nuse Alpha;\nuse Zed;\n",
+		},
+		{
+			"declare ends a block",
+			"<?php\nuse Zed;\nuse Alpha;\ndeclare(strict_types=1);\nuse Beta;\nuse Aaa;\n",
+			"<?php\nuse Alpha;\nuse Zed;\ndeclare(strict_types=1);\nuse Aaa;\nuse Beta;\n",
+		},
+		{
+			"namespace ends a block",
+			"<?php\nnamespace A;\nuse Zed;\nuse Alpha;\nnamespace B;\nuse Yy;\nuse Bb;\n",
+			"<?php\nnamespace A;\nuse Alpha;\nuse Zed;\nnamespace B;\nuse Bb;\nuse Yy;\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, &Config{}, nil, tt.src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	tests := []struct {
+		name, config, src, want string
+	}{
+		{"ignore", `{}`, "<?php\n// psort:ignore\nuse B;\nuse A;", "<?php\n// psort:ignore\nuse B;\nuse A;"},
+		{"ignore beats ensure_final_newline", `{"ensure_final_newline": true}`, "<?php\n# psort:ignore\nuse B;\nuse A;", "<?php\n# psort:ignore\nuse B;\nuse A;"},
+		{"ignore past line 20", `{}`, "<?php\n" + strings.Repeat("\n", 20) + "// psort:ignore\nuse B;\nuse A;\n", "<?php\n" + strings.Repeat("\n", 20) + "// psort:ignore\nuse A;\nuse B;\n"},
+		{
+			"disabled region",
+			`{}`,
+			"<?php\nuse Zed;\nuse Bar;\n// psort:disable\nuse Yy;\nuse Xx;\n// psort:enable\nuse Dd;\nuse Cc;\n",
+			"<?php\nuse Bar;\nuse Zed;\n// psort:disable\nuse Yy;\nuse Xx;\n// psort:enable\nuse Cc;\nuse Dd;\n",
+		},
+		{"disabled to the end", `{}`, "<?php\nuse Zed;\n/* psort:disable */\nuse Yy;\nuse Xx;\n", "<?php\nuse Zed;\n/* psort:disable */\nuse Yy;\nuse Xx;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlankLinesBetweenGroups(t *testing.T) {
+	const src = "<?php\nuse Zed;\nuse App\\Foo;\nuse Lib\\Bar;\n\nclass X {}\n"
+	const groups = `"groups": ["App\\", "Lib\\", "*"]`
+	tests := []struct {
+		name, config, want string
+	}{
+		{"0", `{` + groups + `, "blank_lines_between_groups": 0}`, "<?php\nuse App\\Foo;\nuse Lib\\Bar;\nuse Zed;\n\nclass X {}\n"},
+		{"1", `{` + groups + `, "blank_lines_between_groups": 1}`, "<?php\nuse App\\Foo;\n\nuse Lib\\Bar;\n\nuse Zed;\n\nclass X {}\n"},
+		{"2", `{` + groups + `, "blank_lines_between_groups": 2}`, "<?php\nuse App\\Foo;\n\n\nuse Lib\\Bar;\n\n\nuse Zed;\n\nclass X {}\n"},
+		{"newline_between_groups", `{` + groups + `, "newline_between_groups": true}`, "<?php\nuse App\\Foo;\n\nuse Lib\\Bar;\n\nuse Zed;\n\nclass X {}\n"},
+		{"0 overrides newline_between_groups", `{` + groups + `, "newline_between_groups": true, "blank_lines_between_groups": 0}`, "<?php\nuse App\\Foo;\nuse Lib\\Bar;\nuse Zed;\n\nclass X {}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			// Path casing is kept, only the keyword is lowercased
+			"aliases",
+			"<?php\nuse  App\\Foo  AS  Bar ;\nuse App\\Baz As Qux;\n",
+			"<?php\nuse App\\Baz as Qux;\nuse App\\Foo as Bar;\n",
+		},
+		{
+			"qualifiers and group use",
+			"<?php\nuse App\\{ Foo ,Bar };\nuse   function  App\\helper ;\nuse const App\\MAX  ;\n",
+			"<?php\nuse App\\{Bar, Foo};\nuse function App\\helper;\nuse const App\\MAX;\n",
+		},
+		{
+			// Sorted by the normalized form
+			"sort key",
+			"<?php\nuse App\\Models\\User AS Zed;\nuse App\\Models\\User    as Alpha;\n",
+			"<?php\nuse App\\Models\\User as Alpha;\nuse App\\Models\\User as Zed;\n",
+		},
+		{"indentation kept", "<?php\n    use  App\\B;\n    use App\\A;\n", "<?php\n    use App\\A;\n    use App\\B;\n"},
+		{"trailing comment kept", "<?php\nuse App\\Z;\nuse  App\\A ; // trailing\n", "<?php\nuse App\\A; // trailing\nuse App\\Z;\n"},
+	}
+	config := loadTestConfig(t, `{"normalize_whitespace": true}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, config, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// Without the option the spacing is left as written
+	const src = "<?php\nuse  App\\Foo  AS  Bar ;\n"
+	if got := sortSource(t, &Config{}, nil, src); got != src {
+		t.Errorf("default: got %q, want %q", got, src)
+	}
+}
+
+func TestStripLeadingBackslash(t *testing.T) {
+	const src = "<?php\nuse \\App\\Foo;\nuse Zed;\nuse App\\Foo;\nuse function \\App\\helper;\nuse const \\App\\MAX;\n    use \\App\\Bar as B;\n"
+	tests := []struct {
+		name, config, want string
+	}{
+		{"default", `{}`, "<?php\nuse App\\Foo;\nuse Zed;\n    use \\App\\Bar as B;\nuse \\App\\Foo;\nuse function \\App\\helper;\nuse const \\App\\MAX;\n"},
+		// Both forms of App\Foo merge, qualifiers and indentation stay
+		{"stripped", `{"strip_leading_backslash": true}`, "<?php\n    use App\\Bar as B;\nuse App\\Foo;\nuse Zed;\nuse function App\\helper;\nuse const App\\MAX;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortFileTempBesideTarget(t *testing.T) {
+	path := writeUnsorted(t)
+	// Nothing may be created in $TMPDIR
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	var temp string
+	t.Cleanup(func() { renameFile = os.Rename })
+	renameFile = func(from, to string) error {
+		temp = from
+		return os.Rename(from, to)
+	}
+	if _, err := SortFile(path, Config{}); err != nil {
+		t.Fatalf("SortFile: %v", err)
+	}
+	if filepath.Dir(temp) != filepath.Dir(path) {
+		t.Errorf("temp file %s is not beside %s", temp, path)
+	}
+	if matched, _ := filepath.Match(".test.php.psort-*.tmp", filepath.Base(temp)); !matched {
+		t.Errorf("temp file %s is not a hidden .test.php.psort-*.tmp file", temp)
+	}
+	// The temp file was renamed over the target, nothing is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only test.php", len(entries))
+	}
+}
+
+func TestIndentationPreserved(t *testing.T) {
+	const src = "<?php\nnamespace App {\n\tuse \\Zed\\Foo;\n\tuse \\Alpha\\Bar;\n}\nnamespace Other {\n    use Lib\\B;\n    use Lib\\A;\n}\n"
+	tests := []struct {
+		name, config, src, want string
+	}{
+		{"strip_leading_backslash", `{"strip_leading_backslash": true}`, src, "<?php\nnamespace App {\n\tuse Alpha\\Bar;\n\tuse Zed\\Foo;\n}\nnamespace Other {\n    use Lib\\A;\n    use Lib\\B;\n}\n"},
+		{"collapse", `{"group_use": "collapse"}`, src, "<?php\nnamespace App {\n\tuse \\Alpha\\Bar;\n\tuse \\Zed\\Foo;\n}\nnamespace Other {\n    use Lib\\{A, B};\n}\n"},
+		{"expand", `{"group_use": "expand"}`, "<?php\n  use App\\{B, A};\n", "<?php\n  use App\\A;\n  use App\\B;\n"},
+		{"normalize_casing_from", `{"normalize_casing_from": "classmap.json"}`, "<?php\n\tuse app\\Foo;\n\tuse App\\Bar;\n", "<?php\n\tuse App\\Bar;\n\tuse App\\Foo;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "classmap.json"), []byte(`["App\\Foo", "App\\Bar"]`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, ConfigFileName)
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := sortSource(t, config, nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	// Well past bufio.Scanner's 64 KiB default limit
+	code := "$data = '" + strings.Repeat("x", 100*1024) + "';\n"
+	var members []string
+	for i := 9999; i >= 0; i-- {
+		members = append(members, fmt.Sprintf("Class%04d", i))
+	}
+	groupUse := "use App\\{" + strings.Join(members, ", ") + "};\n"
+	slices.Reverse(members)
+	sortedGroupUse := "use App\\{" + strings.Join(members, ", ") + "};\n"
+
+	tests := []struct {
+		name, src, want string
+	}{
+		{"long code line", "<?php\nuse B;\nuse A;\n" + code, "<?php\nuse A;\nuse B;\n" + code},
+		{"long group use", "<?php\nuse Zed;\n" + groupUse, "<?php\n" + sortedGroupUse + "use Zed;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.src) < 64*1024 {
+				t.Fatalf("source is only %d bytes", len(tt.src))
+			}
+			var out strings.Builder
+			changed, err := SortReader(strings.NewReader(tt.src), &out, Config{})
+			if err != nil {
+				t.Fatalf("SortReader: %v", err)
+			}
+			if !changed || out.String() != tt.want {
+				t.Errorf("changed = %v, output of %d bytes differs from the %d expected", changed, out.Len(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestEqualKeysDeterministic(t *testing.T) {
+	tests := []struct {
+		name, config string
+		imports      []string
+	}{
+		{"case folded", `{"case_sensitive": false}`, []string{"use app\\foo;", "use App\\Foo;", "use APP\\FOO;", "use App\\foo;"}},
+		{"same alias", `{"sort_by_alias": true}`, []string{"use B\\Y as Same;", "use A\\X as Same;", "use C\\Same;", "use A\\Same; // comment"}},
+		{"same length", `{"sort_by": "length"}`, []string{"use Bb;", "use Ab;", "use Ba;", "use Aa;"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, tt.config)
+			var first string
+			// Every rotation of the input, a few times over, sorts the same
+			for run := 0; run < 3*len(tt.imports); run++ {
+				offset := run % len(tt.imports)
+				rotated := append(slices.Clone(tt.imports[offset:]), tt.imports[:offset]...)
+				got := sortSource(t, config, nil, "<?php\n"+strings.Join(rotated, "\n")+"\n")
+				if run == 0 {
+					first = got
+				} else if got != first {
+					t.Fatalf("run %d gave:\n%s\nrun 0 gave:\n%s", run, got, first)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipGenerated(t *testing.T) {
+	const generated = "<?php\n// Code generated by protoc. DO NOT EDIT.\nuse B;\nuse A;"
+	const marked = "<?php\n/** @generated */\nuse B;\nuse A;\n"
+	const custom = "<?php\n// Built by make: keep out\nuse B;\nuse A;\n"
+	tests := []struct {
+		name, config, src, want string
+	}{
+		{"generated", `{"skip_generated": true}`, generated, generated},
+		{"@generated", `{"skip_generated": true}`, marked, marked},
+		{"normal file", `{"skip_generated": true}`, "<?php\n// A regular file\nuse B;\nuse A;\n", "<?php\n// A regular file\nuse A;\nuse B;\n"},
+		{"disabled", `{}`, marked, "<?php\n/** @generated */\nuse A;\nuse B;\n"},
+		{"custom marker", `{"skip_generated": true, "generated_marker": "^// Built by make"}`, custom, custom},
+		{"marker after line 20", `{"skip_generated": true}`, "<?php\n" + strings.Repeat("\n", 20) + "// @generated\nuse B;\nuse A;\n", "<?php\n" + strings.Repeat("\n", 20) + "// @generated\nuse A;\nuse B;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSource(t, loadTestConfig(t, tt.config), nil, tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+<?php
+
+use App\Models\User;
+use Carbon\Carbon;
+use App\Http\Request;
+use Illuminate\Support\Str;
+use App\Models\Post;
+use Closure;
+use App\Http\Response;
+use Illuminate\Support\Arr;
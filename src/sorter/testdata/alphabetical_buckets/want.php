@@ -0,0 +1,13 @@
+<?php
+
+use Carbon\Carbon;
+use Closure;
+
+use Illuminate\Support\Arr;
+use Illuminate\Support\Str;
+
+use App\Http\Request;
+use App\Http\Response;
+
+use App\Models\Post;
+use App\Models\User;
@@ -0,0 +1,26 @@
+<?php
+
+/**
+ * Example:
+ *
+ * use Zeta\Fake;
+ * use Alpha\Fake;
+ */
+
+use Alpha\Real;
+use Zeta\Real;
+
+/*
+use Zeta\Commented;
+use Alpha\Commented;
+*/
+
+$template = <<<PHP
+use Zeta\InHeredoc;
+use Alpha\InHeredoc;
+PHP;
+
+$raw = <<<'PHP'
+    use Zeta\InNowdoc;
+    use Alpha\InNowdoc;
+    PHP;
@@ -0,0 +1,17 @@
+<?php
+use Zeta\Layout;
+use Alpha\Theme;
+?>
+<header>
+use Not\An\Import;
+use Also\Not;
+</header>
+<?php
+use Beta\Widget;
+use Alpha\Card;
+?>
+<footer><?php echo Layout::footer(); ?></footer>
+<?php use Gamma\Late; ?>
+<script>
+use strict;
+</script>
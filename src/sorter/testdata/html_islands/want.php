@@ -0,0 +1,17 @@
+<?php
+use Alpha\Theme;
+use Zeta\Layout;
+?>
+<header>
+use Not\An\Import;
+use Also\Not;
+</header>
+<?php
+use Alpha\Card;
+use Beta\Widget;
+?>
+<footer><?php echo Layout::footer(); ?></footer>
+<?php use Gamma\Late; ?>
+<script>
+use strict;
+</script>
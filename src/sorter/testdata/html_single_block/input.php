@@ -0,0 +1,21 @@
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Use the force</title>
+</head>
+<body>
+<p>
+use Zeta;
+use Alpha;
+</p>
+<?php
+use App\Models\User;
+use App\Helpers\Format;
+use function App\Helpers\money;
+
+$user = User::find(1);
+?>
+<h1><?= Format::name($user) ?></h1>
+<p><?= money($user->balance) ?></p>
+</body>
+</html>
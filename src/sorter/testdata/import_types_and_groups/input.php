@@ -0,0 +1,15 @@
+<?php
+
+namespace App\Http;
+
+use function App\helper;
+use App\Models\User;
+use const PHP_EOL;
+use Illuminate\Support\Str;
+use function array_map;
+use const App\VERSION;
+use App\Models\Post;
+
+class Controller
+{
+}
@@ -0,0 +1,20 @@
+<?php
+
+namespace App\Http;
+
+use Illuminate\Support\Str;
+
+use App\Models\Post;
+use App\Models\User;
+
+use function array_map;
+
+use function App\helper;
+
+use const PHP_EOL;
+
+use const App\VERSION;
+
+class Controller
+{
+}
@@ -0,0 +1,11 @@
+<?php
+
+use function strlen;
+use App\Models\User;
+use function array_map;
+use App\Http\Request;
+
+function size(User $user): int
+{
+    return strlen($user->name);
+}
@@ -0,0 +1,12 @@
+<?php
+
+use App\Http\Request;
+use App\Models\User;
+
+use function array_map;
+use function strlen;
+
+function size(User $user): int
+{
+    return strlen($user->name);
+}
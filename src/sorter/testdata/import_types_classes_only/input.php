@@ -0,0 +1,11 @@
+<?php
+
+namespace App\Http;
+
+use App\Models\User;
+use Illuminate\Support\Str;
+use App\Models\Post;
+
+class Controller
+{
+}
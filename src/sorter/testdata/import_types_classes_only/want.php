@@ -0,0 +1,11 @@
+<?php
+
+namespace App\Http;
+
+use Illuminate\Support\Str;
+use App\Models\Post;
+use App\Models\User;
+
+class Controller
+{
+}
@@ -0,0 +1,8 @@
+<?php
+
+use const PHP_EOL;
+use function strlen;
+use const App\VERSION;
+use function array_map;
+
+echo strlen(VERSION), PHP_EOL;
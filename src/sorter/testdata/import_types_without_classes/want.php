@@ -0,0 +1,9 @@
+<?php
+
+use function array_map;
+use function strlen;
+
+use const App\VERSION;
+use const PHP_EOL;
+
+echo strlen(VERSION), PHP_EOL;
@@ -0,0 +1,9 @@
+<?php
+
+namespace App\Tests\Unit;
+
+use App\Tests\TestCase;
+use PHPUnit\Framework\Attributes\Test;
+use App\Models\User;
+use App\TestsHelper;
+use App\Tests\Factories\UserFactory;
@@ -0,0 +1,11 @@
+<?php
+
+namespace App\Tests\Unit;
+
+use App\Models\User;
+use App\TestsHelper;
+
+use App\Tests\Factories\UserFactory;
+use App\Tests\TestCase;
+
+use PHPUnit\Framework\Attributes\Test;
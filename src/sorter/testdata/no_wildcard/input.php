@@ -0,0 +1,10 @@
+<?php
+
+namespace App\Http;
+
+use Carbon\Carbon;
+use App\Models\User;
+use Illuminate\Support\Str;
+use Monolog\Logger;
+
+class Controller {}
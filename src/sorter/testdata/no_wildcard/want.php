@@ -0,0 +1,12 @@
+<?php
+
+namespace App\Http;
+
+use App\Models\User;
+
+use Illuminate\Support\Str;
+
+use Carbon\Carbon;
+use Monolog\Logger;
+
+class Controller {}
@@ -0,0 +1,10 @@
+<?php
+
+namespace App\Tests\Feature;
+
+use App\Tests\TestCase;
+use Illuminate\Support\Str;
+use Vendor\Package\Tests\Helper;
+use App\Models\User;
+use PHPUnit\Framework\Assert;
+use App\Testsuite\Runner;
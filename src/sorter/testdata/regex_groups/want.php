@@ -0,0 +1,12 @@
+<?php
+
+namespace App\Tests\Feature;
+
+use Illuminate\Support\Str;
+use PHPUnit\Framework\Assert;
+
+use App\Models\User;
+use App\Tests\TestCase;
+use App\Testsuite\Runner;
+
+use Vendor\Package\Tests\Helper;
@@ -0,0 +1,13 @@
+<?php
+
+namespace App\Http\Controllers;
+
+use App\Http\Controllers\Controller;
+use Illuminate\Http\Request;
+use App\Models\User;
+use App\Http\Controllers\Api\Base;
+use App\Http\ControllersExtra\Helper;
+
+class UserController extends Controller
+{
+}
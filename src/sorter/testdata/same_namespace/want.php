@@ -0,0 +1,15 @@
+<?php
+
+namespace App\Http\Controllers;
+
+use Illuminate\Http\Request;
+
+use App\Http\ControllersExtra\Helper;
+use App\Models\User;
+
+use App\Http\Controllers\Api\Base;
+use App\Http\Controllers\Controller;
+
+class UserController extends Controller
+{
+}
@@ -0,0 +1,9 @@
+<?php
+
+namespace App;
+
+use AppBar;
+use App\Foo;
+use App\Sub\Thing;
+use App_Legacy\Helper;
+use Apps\Registry;
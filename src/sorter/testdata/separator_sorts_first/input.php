@@ -0,0 +1,9 @@
+<?php
+
+namespace App;
+
+use AppBar;
+use App\Sub\Thing;
+use App_Legacy\Helper;
+use App\Foo;
+use Apps\Registry;
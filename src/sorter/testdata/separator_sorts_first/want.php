@@ -0,0 +1,9 @@
+<?php
+
+namespace App;
+
+use App\Foo;
+use App\Sub\Thing;
+use AppBar;
+use App_Legacy\Helper;
+use Apps\Registry;
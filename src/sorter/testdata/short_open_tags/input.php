@@ -0,0 +1,17 @@
+<div class="profile">
+<?
+use Zeta\Avatar;
+use Alpha\Badge;
+?>
+<p>
+use Not\An\Import;
+use Also\Not;
+</p>
+<?= Avatar::render($user) ?>
+<?
+use Beta\Card;
+use Alpha\Card as AlphaCard;
+
+echo Card::of($user), AlphaCard::of($user);
+?>
+</div>
@@ -0,0 +1,17 @@
+<div class="profile">
+<?
+use Alpha\Badge;
+use Zeta\Avatar;
+?>
+<p>
+use Not\An\Import;
+use Also\Not;
+</p>
+<?= Avatar::render($user) ?>
+<?
+use Alpha\Card as AlphaCard;
+use Beta\Card;
+
+echo Card::of($user), AlphaCard::of($user);
+?>
+</div>
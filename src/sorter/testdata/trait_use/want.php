@@ -0,0 +1,30 @@
+<?php
+
+namespace App;
+
+use Alpha\Foo;
+use Zed\Bar;
+
+class Foo
+{
+    use ZTrait;
+    use ATrait;
+
+    public function handler()
+    {
+        return function () use ($bar) {
+            return $bar;
+        };
+    }
+}
+
+trait Loggable
+{
+    use Timestamps, Alpha;
+}
+
+enum Status
+{
+    use Zeta;
+    use Beta;
+}
@@ -0,0 +1,10 @@
+<?php
+
+namespace App\Http;
+
+use App\Models\User;
+use Illuminate\Support\Str;
+use App\Models\Post;
+use Carbon\Carbon;
+
+class Controller {}
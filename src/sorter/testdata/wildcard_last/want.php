@@ -0,0 +1,11 @@
+<?php
+
+namespace App\Http;
+
+use App\Models\Post;
+use App\Models\User;
+
+use Carbon\Carbon;
+use Illuminate\Support\Str;
+
+class Controller {}
@@ -0,0 +1,67 @@
+package sorter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// lineImport is a use statement along with the line it starts on.
+type lineImport struct {
+	line   string
+	lineNo int
+}
+
+// importedName is a name a use statement makes available, as referenced in
+// code: an alias or the last segment of the imported name.
+type importedName struct {
+	kind importKind
+	name string
+}
+
+// reportUnused warns about the names imported by a file's use statements that
+// appear nowhere in the rest of it, body holding every line that is not a use
+// statement. The check is a whole-word search, so a name mentioned only in a
+// comment or a string counts as used, and dynamic references are not seen;
+// it is a hint, nothing is removed.
+func reportUnused(imports []lineImport, body string, filePath string, opts *Options) {
+	for _, imp := range imports {
+		for _, imported := range importedNames(imp.line) {
+			pattern := `\b` + regexp.QuoteMeta(imported.name) + `\b`
+			if imported.kind != kindConst {
+				// Class and function names are case-insensitive in PHP
+				pattern = "(?i)" + pattern
+			}
+			if !regexp.MustCompile(pattern).MatchString(body) {
+				opts.warnf("%s:%d: %s %s appears to be unused", filePath, imp.lineNo, imported.kind, imported.name)
+			}
+		}
+	}
+}
+
+// importedNames returns the names a use statement imports, one per member
+// of a group use.
+func importedNames(line string) []importedName {
+	kind, importPath := parseImport(line)
+	open := strings.Index(importPath, "{")
+	closing := strings.LastIndex(importPath, "}")
+	if open < 0 || closing < open {
+		return []importedName{{kind, referencedName(importPath)}}
+	}
+
+	var names []importedName
+	for _, member := range strings.Split(importPath[open+1:closing], ",") {
+		member = strings.Join(strings.Fields(member), " ")
+		if member == "" {
+			continue
+		}
+		memberKind := kind
+		for _, k := range []importKind{kindFunction, kindConst} {
+			if strings.HasPrefix(member, importQualifier(k)) {
+				memberKind = k
+				member = strings.TrimPrefix(member, importQualifier(k))
+			}
+		}
+		names = append(names, importedName{memberKind, referencedName(member)})
+	}
+	return names
+}
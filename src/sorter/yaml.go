@@ -0,0 +1,504 @@
+package sorter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlError is a syntax error in a YAML config, or a construct the subset
+// read by yamlToJSON doesn't support.
+type yamlError struct {
+	line int
+	msg  string
+}
+
+func (e *yamlError) Error() string {
+	return e.msg
+}
+
+// yamlNumber matches the plain scalars read as numbers: those JSON accepts.
+var yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// yamlToJSON converts a YAML config to the equivalent JSON, so that it goes
+// through the same validation and decoding as psort.json, along with the
+// line of each field, named as in fieldError.
+//
+// Only the subset of YAML a config needs is read: block mappings and
+// sequences, flow collections on a single line (`[a, b]`, `{}`), plain,
+// single- and double-quoted scalars, `|` and `>` block scalars and comments.
+// Anchors, aliases, tags and multiple documents are rejected.
+func yamlToJSON(data []byte) ([]byte, map[string]int, error) {
+	p := &yamlParser{fieldLines: map[string]int{}}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line := yamlLine{no: i + 1, raw: raw}
+		// A document may start with `---` and end with `...`
+		if raw == "---" && p.peek() < 0 {
+			p.lines = nil
+			continue
+		}
+		if raw == "..." {
+			break
+		}
+		if raw == "---" {
+			return nil, nil, &yamlError{line: line.no, msg: "multiple documents are not supported"}
+		}
+		if strings.HasPrefix(strings.TrimLeft(raw, " "), "\t") && strings.TrimSpace(raw) != "" {
+			return nil, nil, &yamlError{line: line.no, msg: "tabs are not allowed in indentation"}
+		}
+		p.lines = append(p.lines, line)
+	}
+
+	var root interface{} = map[string]interface{}{}
+	if i := p.peek(); i >= 0 {
+		if p.lines[i].indent() != 0 {
+			return nil, nil, p.errorf(i, "unexpected indentation")
+		}
+		value, err := p.parseNode(0, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		root = value
+	}
+	if i := p.peek(); i >= 0 {
+		return nil, nil, p.errorf(i, "unexpected indentation")
+	}
+
+	converted, err := json.Marshal(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, p.fieldLines, nil
+}
+
+// yamlLine is a line of a YAML document. An item of a block sequence is
+// consumed by moving its start past the `- `, so that what follows is read
+// as a node of its own.
+type yamlLine struct {
+	no    int
+	raw   string
+	start int
+}
+
+// indent is the column the line's content starts at.
+func (l yamlLine) indent() int {
+	return l.start + len(l.text()) - len(strings.TrimLeft(l.text(), " "))
+}
+
+func (l yamlLine) text() string {
+	return l.raw[l.start:]
+}
+
+// content is the line without indentation, trailing spaces and comment.
+func (l yamlLine) content() string {
+	return strings.TrimSpace(stripYAMLComment(l.text()))
+}
+
+type yamlParser struct {
+	lines      []yamlLine
+	pos        int
+	fieldLines map[string]int
+}
+
+func (p *yamlParser) errorf(index int, format string, args ...interface{}) error {
+	return &yamlError{line: p.lines[index].no, msg: fmt.Sprintf(format, args...)}
+}
+
+// peek returns the index of the next line with content, skipping blank and
+// comment lines, or -1 at the end of the document.
+func (p *yamlParser) peek() int {
+	for i := p.pos; i < len(p.lines); i++ {
+		if p.lines[i].content() != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseNode reads the block node starting at the next line, which is
+// indented by indent.
+func (p *yamlParser) parseNode(indent int, path string) (interface{}, error) {
+	i := p.peek()
+	content := p.lines[i].content()
+	if isSequenceItem(content) {
+		return p.parseSequence(indent, path)
+	}
+	if _, _, ok := splitYAMLKey(content); ok {
+		return p.parseMapping(indent, path)
+	}
+	p.pos = i + 1
+	return parseYAMLValue(content, p.lines[i].no)
+}
+
+func (p *yamlParser) parseMapping(indent int, path string) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for {
+		i := p.peek()
+		if i < 0 || p.lines[i].indent() < indent {
+			return mapping, nil
+		}
+		line := p.lines[i]
+		if line.indent() > indent {
+			return nil, p.errorf(i, "unexpected indentation")
+		}
+		key, rest, ok := splitYAMLKey(line.content())
+		if !ok {
+			return nil, p.errorf(i, "expected `key: value`")
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, p.errorf(i, "duplicate key %q", key)
+		}
+		field := joinPath(path, key)
+		p.fieldLines[field] = line.no
+		p.pos = i + 1
+
+		value, err := p.parseValue(i, rest, indent, field, true)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int, path string) (interface{}, error) {
+	sequence := []interface{}{}
+	for {
+		i := p.peek()
+		if i < 0 || p.lines[i].indent() != indent || !isSequenceItem(p.lines[i].content()) {
+			if i >= 0 && p.lines[i].indent() > indent {
+				return nil, p.errorf(i, "unexpected indentation")
+			}
+			return sequence, nil
+		}
+		field := fmt.Sprintf("%s[%d]", path, len(sequence))
+		p.fieldLines[field] = p.lines[i].no
+
+		line := &p.lines[i]
+		afterDash := line.indent() + 1
+		rest := strings.TrimLeft(line.raw[afterDash:], " ")
+		if strings.TrimSpace(stripYAMLComment(rest)) == "" {
+			p.pos = i + 1
+			value, err := p.parseValue(i, "", indent, field, false)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, value)
+			continue
+		}
+		// Read the rest of the line as a node indented past the dash, so
+		// `- prefix: App` starts a mapping that continues on the next lines
+		line.start = len(line.raw) - len(rest)
+		value, err := p.parseNode(line.indent(), field)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, value)
+	}
+}
+
+// parseValue reads the value of the entry on line index, rest being what
+// follows its key or dash. An empty rest is a nested block, a null if there
+// is none. In a mapping, a sequence may be nested at the same indentation.
+func (p *yamlParser) parseValue(index int, rest string, indent int, field string, inMapping bool) (interface{}, error) {
+	if rest == "|" || rest == "|-" || rest == "|+" || rest == ">" || rest == ">-" || rest == ">+" {
+		return p.parseBlockScalar(rest, indent), nil
+	}
+	if rest != "" {
+		return parseYAMLValue(rest, p.lines[index].no)
+	}
+	next := p.peek()
+	if next < 0 {
+		return nil, nil
+	}
+	if p.lines[next].indent() > indent {
+		return p.parseNode(p.lines[next].indent(), field)
+	}
+	if inMapping && p.lines[next].indent() == indent && isSequenceItem(p.lines[next].content()) {
+		return p.parseSequence(indent, field)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar reads the lines of a `|` (literal) or `>` (folded) block
+// scalar indented past indent, with `-` and `+` chomping.
+func (p *yamlParser) parseBlockScalar(header string, indent int) string {
+	var lines []string
+	contentIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos].raw
+		if strings.TrimSpace(raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if lineIndent <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = lineIndent
+		}
+		lines = append(lines, raw[min(contentIndent, lineIndent):])
+	}
+
+	var text string
+	if header[0] == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		// Folded: lines join with spaces, blank lines become newlines
+		var b strings.Builder
+		for i, line := range lines {
+			switch {
+			case line == "":
+				b.WriteString("\n")
+			case i > 0 && lines[i-1] != "":
+				b.WriteString(" " + line)
+			default:
+				b.WriteString(line)
+			}
+		}
+		text = b.String()
+	}
+	content := strings.TrimRight(text, "\n")
+	switch {
+	case strings.HasSuffix(header, "-"):
+		return content
+	case strings.HasSuffix(header, "+"):
+		return text + "\n"
+	case content == "":
+		return ""
+	}
+	return content + "\n"
+}
+
+// isSequenceItem reports whether a line's content is an item of a block
+// sequence.
+func isSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// splitYAMLKey splits the content of a mapping entry into its key and value,
+// reporting false if it is not one.
+func splitYAMLKey(content string) (string, string, bool) {
+	if content == "" || strings.ContainsRune("[{", rune(content[0])) {
+		return "", "", false
+	}
+	if content[0] == '"' || content[0] == '\'' {
+		key, n, err := parseQuoted(content)
+		if err != nil {
+			return "", "", false
+		}
+		rest := content[n:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	colon := strings.Index(content, ": ")
+	if colon < 0 {
+		if !strings.HasSuffix(content, ":") {
+			return "", "", false
+		}
+		colon = len(content) - 1
+	}
+	return strings.TrimSpace(content[:colon]), strings.TrimSpace(content[colon+1:]), true
+}
+
+// stripYAMLComment removes a `#` comment from a line, outside quotes. A `#`
+// only starts a comment at the start of the line or after a space.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:", text[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// parseYAMLValue reads a value written inline: a flow collection, a quoted
+// scalar or a plain one.
+func parseYAMLValue(text string, lineNo int) (interface{}, error) {
+	value, n, err := parseFlowValue(text, false)
+	if err == nil && strings.TrimSpace(text[n:]) != "" {
+		err = fmt.Errorf("unexpected %q after value", strings.TrimSpace(text[n:]))
+	}
+	if err != nil {
+		return nil, &yamlError{line: lineNo, msg: err.Error()}
+	}
+	return value, nil
+}
+
+// parseFlowValue reads the value at the start of text and returns it with
+// the number of bytes read. Inside a flow collection, plain scalars end at
+// `,`, `]` and `}`.
+func parseFlowValue(text string, inFlow bool) (interface{}, int, error) {
+	trimmed := strings.TrimLeft(text, " ")
+	offset := len(text) - len(trimmed)
+	if trimmed == "" {
+		if inFlow {
+			return nil, offset, fmt.Errorf("unterminated flow collection")
+		}
+		return nil, offset, nil
+	}
+	switch trimmed[0] {
+	case '[':
+		value, n, err := parseFlowSequence(trimmed)
+		return value, offset + n, err
+	case '{':
+		value, n, err := parseFlowMapping(trimmed)
+		return value, offset + n, err
+	case '"', '\'':
+		value, n, err := parseQuoted(trimmed)
+		return value, offset + n, err
+	case '&', '*', '!':
+		return nil, offset, fmt.Errorf("anchors, aliases and tags are not supported")
+	case '|', '>', '%', '@', '`':
+		return nil, offset, fmt.Errorf("unexpected %q", trimmed[0])
+	}
+
+	end := len(trimmed)
+	if inFlow {
+		if i := strings.IndexAny(trimmed, ",]}"); i >= 0 {
+			end = i
+		}
+	}
+	return resolvePlain(strings.TrimSpace(trimmed[:end])), offset + end, nil
+}
+
+func parseFlowSequence(text string) (interface{}, int, error) {
+	sequence := []interface{}{}
+	pos := 1
+	for {
+		rest := strings.TrimLeft(text[pos:], " ")
+		pos = len(text) - len(rest)
+		if strings.HasPrefix(rest, "]") {
+			return sequence, pos + 1, nil
+		}
+		value, n, err := parseFlowValue(text[pos:], true)
+		if err != nil {
+			return nil, 0, err
+		}
+		sequence = append(sequence, value)
+		if pos, err = flowSeparator(text, pos+n, ']'); err != nil {
+			return nil, 0, err
+		}
+		if text[pos-1] == ']' {
+			return sequence, pos, nil
+		}
+	}
+}
+
+func parseFlowMapping(text string) (interface{}, int, error) {
+	mapping := map[string]interface{}{}
+	pos := 1
+	for {
+		rest := strings.TrimLeft(text[pos:], " ")
+		pos = len(text) - len(rest)
+		if strings.HasPrefix(rest, "}") {
+			return mapping, pos + 1, nil
+		}
+		var name string
+		if rest[0] == '"' || rest[0] == '\'' {
+			key, n, err := parseQuoted(rest)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, pos = key, pos+n
+		} else {
+			// A plain key ends at its colon
+			end := strings.IndexAny(rest, ":,}")
+			if end < 0 {
+				return nil, 0, fmt.Errorf("unterminated flow collection")
+			}
+			name, pos = strings.TrimSpace(rest[:end]), pos+end
+		}
+		rest = strings.TrimLeft(text[pos:], " ")
+		if !strings.HasPrefix(rest, ":") {
+			return nil, 0, fmt.Errorf("expected `:` after key %q", name)
+		}
+		pos = len(text) - len(rest) + 1
+		value, n, err := parseFlowValue(text[pos:], true)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, exists := mapping[name]; exists {
+			return nil, 0, fmt.Errorf("duplicate key %q", name)
+		}
+		mapping[name] = value
+		if pos, err = flowSeparator(text, pos+n, '}'); err != nil {
+			return nil, 0, err
+		}
+		if text[pos-1] == '}' {
+			return mapping, pos, nil
+		}
+	}
+}
+
+// flowSeparator skips the `,` or closing bracket after an entry of a flow
+// collection and returns the position past it.
+func flowSeparator(text string, pos int, closing byte) (int, error) {
+	rest := strings.TrimLeft(text[pos:], " ")
+	if rest == "" {
+		return 0, fmt.Errorf("unterminated flow collection")
+	}
+	if rest[0] != ',' && rest[0] != closing {
+		return 0, fmt.Errorf("expected `,` or %q, found %q", closing, rest[0])
+	}
+	return len(text) - len(rest) + 1, nil
+}
+
+// parseQuoted reads the quoted scalar at the start of text and returns it
+// with the number of bytes read. Single quotes are escaped by doubling them,
+// double-quoted scalars take the escapes of JSON.
+func parseQuoted(text string) (string, int, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(text[1:i], "''", "'"), i + 1, nil
+			}
+			value, err := strconv.Unquote(text[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid escape in %s", text[:i+1])
+			}
+			return value, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+// resolvePlain returns the value of a plain scalar: null, a boolean, a
+// number or else the string itself, so that `App\Models` needs no quotes.
+func resolvePlain(text string) interface{} {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlNumber.MatchString(text) {
+		return json.Number(text)
+	}
+	return text
+}
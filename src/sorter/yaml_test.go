@@ -0,0 +1,98 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLConfig(t *testing.T) {
+	tests := []struct {
+		name, yaml, json string
+	}{
+		{
+			"scalars",
+			"newline_between_groups: true\nwarn_on_long_imports: 120\nsort_by: depth\nbackup_suffix: '.orig'\npost_command: \"tr -s ' '\"\n",
+			`{"newline_between_groups": true, "warn_on_long_imports": 120, "sort_by": "depth", "backup_suffix": ".orig", "post_command": "tr -s ' '"}`,
+		},
+		{
+			// Quoted, a scalar stays a string whatever it looks like
+			"quoted strings",
+			"groups:\n  - \"*\"\n  - 'App\\'\n  - \"Tests\\\\\"\npinned: ['true', \"12\"]\n",
+			`{"groups": ["*", "App\\", "Tests\\"], "pinned": ["true", "12"]}`,
+		},
+		{
+			"nested maps",
+			"groups: ['*']\norder_overrides:\n  first:\n    - App\\Kernel\n  last: [Zed]\ncomment_group_headers:\n  \"0\": \"// Vendor\"\n",
+			`{"groups": ["*"], "order_overrides": {"first": ["App\\Kernel"], "last": ["Zed"]}, "comment_group_headers": {"0": "// Vendor"}}`,
+		},
+		{
+			"sequence of strings",
+			"include:\n  - src/**/*.php\n  - app/*.php\nexclude: [vendor, build/*]\n",
+			`{"include": ["src/**/*.php", "app/*.php"], "exclude": ["vendor", "build/*"]}`,
+		},
+		{
+			"sequence of group objects",
+			"import_types: interleave\ngroups:\n  - prefix: Illuminate\\\n    header: // Framework\n  - {prefix: App\\, order: desc}\n  - match: App\\\n    kind: function\n  - \"*\"\n",
+			`{"import_types": "interleave", "groups": [{"prefix": "Illuminate\\", "header": "// Framework"}, {"prefix": "App\\", "order": "desc"}, {"match": "App\\", "kind": "function"}, "*"]}`,
+		},
+		{
+			"comments",
+			"# psort config\n---\ngroups: # in order\n  # first party\n  - App\\\n\n  - '*' # the rest\npost_command: \"tr -d '#'\" # not a comment inside quotes\n...\nignored: after the end\n",
+			`{"groups": ["App\\", "*"], "post_command": "tr -d '#'"}`,
+		},
+		{"empty", "# nothing set\n", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			yamlPath, jsonPath := filepath.Join(dir, "psort.yaml"), filepath.Join(dir, ConfigFileName)
+			if err := os.WriteFile(yamlPath, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(jsonPath, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fromYAML, err := LoadConfig(yamlPath)
+			if err != nil {
+				t.Fatalf("LoadConfig(psort.yaml): %v", err)
+			}
+			fromJSON, err := LoadConfig(jsonPath)
+			if err != nil {
+				t.Fatalf("LoadConfig(psort.json): %v", err)
+			}
+			if !reflect.DeepEqual(fromYAML, fromJSON) {
+				t.Errorf("from YAML:\n%+v\nfrom JSON:\n%+v", fromYAML, fromJSON)
+			}
+		})
+	}
+}
+
+func TestYAMLConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, yaml, err string
+	}{
+		{"bad indentation", "groups:\n  - App\\\n   - Vendor\\\n", "psort.yaml:3: unexpected indentation"},
+		{"tab indentation", "groups:\n\t- App\\\n", "psort.yaml:2: tabs are not allowed in indentation"},
+		{"unclosed flow sequence", "exclude: [vendor\n", "psort.yaml:1: unterminated flow collection"},
+		{"unclosed quote", "sort_by: depth\npost_command: \"tr\n", "psort.yaml:2: unterminated quoted string"},
+		{"multiple documents", "sort_by: depth\n---\nsort_by: length\n", "psort.yaml:2: multiple documents are not supported"},
+		{"anchor", "groups: &groups\n  - App\\\n", "psort.yaml:1: anchors, aliases and tags are not supported"},
+		// Validation errors point at the line of the field, as in JSON
+		{"unknown key", "groups: ['*']\nnewline_betwen_groups: true\n", "psort.yaml:2: newline_betwen_groups: unknown option"},
+		{"invalid value", "groups:\n  - App\\\nsort_by: size\n", `psort.yaml:3: sort_by: invalid value "size"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "psort.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadConfig: err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}